@@ -0,0 +1,34 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package authchi plugs authmid into chi routers:
+//
+//	r := chi.NewRouter()
+//	r.Use(authchi.Middleware(vf))
+//
+// Handlers find the authenticated Principal with authmid.PrincipalFromContext.
+package authchi
+
+import (
+	"net/http"
+
+	"github.com/orijtech/authmid"
+)
+
+// Middleware returns authmid.Middleware in chi's middleware signature.
+func Middleware(vf authmid.Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return authmid.Middleware(vf, next)
+	}
+}
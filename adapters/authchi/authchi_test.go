@@ -0,0 +1,41 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authchi_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/adapters/authchi"
+	"github.com/orijtech/authmid/adapters/internal/adaptertest"
+)
+
+func TestMiddleware(t *testing.T) {
+	adaptertest.Test(t, func(vf authmid.Authenticator) adaptertest.Serve {
+		r := chi.NewRouter()
+		r.Use(authchi.Middleware(vf))
+		r.Post("/items", func(w http.ResponseWriter, r *http.Request) {
+			p, _ := authmid.PrincipalFromContext(r.Context())
+			body, _ := ioutil.ReadAll(r.Body)
+			fmt.Fprintf(w, "%s %s", p.APIKey, body)
+		})
+		return adaptertest.Handler(r)
+	})
+}
@@ -0,0 +1,40 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package authecho plugs authmid into echo:
+//
+//	e := echo.New()
+//	e.Use(authecho.Middleware(vf))
+package authecho
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/orijtech/authmid"
+)
+
+// Middleware returns authmid.Middleware as echo middleware, rejected
+// requests get the same responses as from authmid.Middleware.
+func Middleware(vf authmid.Authenticator) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(func(next http.Handler) http.Handler {
+		return authmid.Middleware(vf, next)
+	})
+}
+
+// Principal returns the Principal that Middleware authenticated.
+func Principal(c echo.Context) (*authmid.Principal, bool) {
+	return authmid.PrincipalFromContext(c.Request().Context())
+}
@@ -0,0 +1,40 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authecho_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/adapters/authecho"
+	"github.com/orijtech/authmid/adapters/internal/adaptertest"
+)
+
+func TestMiddleware(t *testing.T) {
+	adaptertest.Test(t, func(vf authmid.Authenticator) adaptertest.Serve {
+		e := echo.New()
+		e.Use(authecho.Middleware(vf))
+		e.POST("/items", func(c echo.Context) error {
+			p, _ := authecho.Principal(c)
+			body, _ := ioutil.ReadAll(c.Request().Body)
+			return c.String(http.StatusOK, p.APIKey+" "+string(body))
+		})
+		return adaptertest.Handler(e)
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package authfiber plugs authmid into fiber:
+//
+//	app := fiber.New()
+//	app.Use(authfiber.Middleware(vf))
+//
+// fiber isn't built on net/http, so requests are converted to
+// *http.Request to be verified.
+package authfiber
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+
+	"github.com/orijtech/authmid"
+)
+
+type principalKey struct{}
+
+// Middleware returns authmid.Middleware as a fiber.Handler, rejected
+// requests get the same responses as from authmid.Middleware.
+func Middleware(vf authmid.Authenticator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := new(http.Request)
+		if err := fasthttpadaptor.ConvertRequest(c.Context(), req, true); err != nil {
+			return err
+		}
+
+		var principal *authmid.Principal
+		passed := false
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			passed = true
+			principal, _ = authmid.PrincipalFromContext(r.Context())
+		})
+		rw := &responseWriter{c: c, hdr: make(http.Header)}
+		authmid.Middleware(vf, next).ServeHTTP(rw, req)
+		if !passed {
+			return nil
+		}
+		c.Locals(principalKey{}, principal)
+		return c.Next()
+	}
+}
+
+// Principal returns the Principal that Middleware authenticated.
+func Principal(c *fiber.Ctx) (*authmid.Principal, bool) {
+	p, ok := c.Locals(principalKey{}).(*authmid.Principal)
+	return p, ok && p != nil
+}
+
+// responseWriter writes the rejections of authmid.Middleware to c.
+type responseWriter struct {
+	c   *fiber.Ctx
+	hdr http.Header
+}
+
+func (rw *responseWriter) Header() http.Header {
+	return rw.hdr
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	for key, values := range rw.hdr {
+		for _, value := range values {
+			rw.c.Response().Header.Add(key, value)
+		}
+	}
+	rw.c.Status(code)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	return rw.c.Write(p)
+}
@@ -0,0 +1,52 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package authgin plugs authmid into gin:
+//
+//	r := gin.New()
+//	r.Use(authgin.Middleware(vf))
+package authgin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/orijtech/authmid"
+)
+
+// Middleware returns authmid.Middleware as a gin.HandlerFunc, rejected
+// requests are aborted with the same responses as from authmid.Middleware.
+func Middleware(vf authmid.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var authenticated *http.Request
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authenticated = r
+		})
+		authmid.Middleware(vf, next).ServeHTTP(c.Writer, c.Request)
+		if authenticated == nil {
+			c.Abort()
+			return
+		}
+		// The rest of the chain runs here rather than within
+		// authmid.Middleware, as gin expects of its middleware.
+		c.Request = authenticated
+		c.Next()
+	}
+}
+
+// Principal returns the Principal that Middleware authenticated.
+func Principal(c *gin.Context) (*authmid.Principal, bool) {
+	return authmid.PrincipalFromContext(c.Request.Context())
+}
@@ -0,0 +1,40 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authgin_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/adapters/authgin"
+	"github.com/orijtech/authmid/adapters/internal/adaptertest"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	adaptertest.Test(t, func(vf authmid.Authenticator) adaptertest.Serve {
+		r := gin.New()
+		r.Use(authgin.Middleware(vf))
+		r.POST("/items", func(c *gin.Context) {
+			p, _ := authgin.Principal(c)
+			body, _ := c.GetRawData()
+			c.String(http.StatusOK, "%s %s", p.APIKey, body)
+		})
+		return adaptertest.Handler(r)
+	})
+}
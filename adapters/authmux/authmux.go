@@ -0,0 +1,36 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package authmux plugs authmid into gorilla/mux routers:
+//
+//	r := mux.NewRouter()
+//	r.Use(authmux.Middleware(vf))
+//
+// Handlers find the authenticated Principal with authmid.PrincipalFromContext.
+package authmux
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/orijtech/authmid"
+)
+
+// Middleware returns authmid.Middleware as a mux.MiddlewareFunc.
+func Middleware(vf authmid.Authenticator) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return authmid.Middleware(vf, next)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package adaptertest checks that the adapters pass and reject requests
+// just like authmid.Middleware.
+package adaptertest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+)
+
+// Serve serves a request with the router under test.
+type Serve func(*http.Request) (*http.Response, error)
+
+// Handler serves requests with h.
+func Handler(h http.Handler) Serve {
+	return func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Result(), nil
+	}
+}
+
+// Test checks the router that mount returns, which should use the adapter
+// with vf and answer "POST /items" with the API key of the Principal, a
+// space and the request body, which must still be readable once verified.
+func Test(t *testing.T, mount func(vf authmid.Authenticator) Serve) {
+	backend, _ := memory.NewWithMap(map[string]string{"key": "secret"})
+	ha := &authmid.HeaderAuthenticator{Backend: backend, KeyHeader: "X-Key", SignatureHeader: "X-Signature"}
+	serve := mount(ha)
+
+	// Rejections must match authmid.Middleware's exactly.
+	bad := httptest.NewRequest("POST", "/items", nil)
+	bad.Header.Set("X-Key", "key")
+	bad.Header.Set("X-Signature", "bogus")
+	ref := httptest.NewRecorder()
+	authmid.Middleware(ha, http.NotFoundHandler()).ServeHTTP(ref, bad)
+
+	good := httptest.NewRequest("POST", "/items", nil)
+	if err := ha.SignRequest(good, "key", []byte("secret")); err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+	withBody := httptest.NewRequest("POST", "/items", strings.NewReader(`{"name":"widget"}`))
+	if err := ha.SignRequest(withBody, "key", []byte("secret")); err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+
+	for i, tt := range []struct {
+		req      *http.Request
+		wantCode int
+		wantBody string
+	}{
+		{req: good, wantCode: http.StatusOK, wantBody: "key "},
+		{req: withBody, wantCode: http.StatusOK, wantBody: `key {"name":"widget"}`},
+		{req: bad, wantCode: ref.Code, wantBody: ref.Body.String()},
+	} {
+		res, err := serve(tt.req)
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.wantCode || string(body) != tt.wantBody {
+			t.Errorf("#%d: got %d %q want %d %q", i, res.StatusCode, body, tt.wantCode, tt.wantBody)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package admin exposes key management for any authmid.Backend over HTTP:
+//
+//	POST   /keys                 create a key, its secret is returned only here
+//	GET    /keys?cursor=&limit=  list keys, requires an authmid.Lister backend
+//	POST   /keys/{key}/rotate    replace a key's secret, returning the new one
+//	POST   /keys/{key}/disable   replace a key's secret with one that is never revealed
+//	DELETE /keys/{key}           delete a key
+//
+// A disabled key is re-enabled by rotating it. Every route is protected by
+// the admin Authenticator, which should be backed by different
+// credentials from those being managed.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/orijtech/authmid"
+)
+
+type Config struct {
+	Backend authmid.Backend
+
+	// Authenticator verifies the admin callers.
+	Authenticator authmid.Authenticator
+
+	// KeyPrefix if set overrides authmid.DefaultKeyPrefix for created keys.
+	KeyPrefix string
+}
+
+var (
+	errNilConfig        = errors.New("expecting a non-nil config")
+	errNilBackend       = errors.New("expecting a non-nil backend")
+	errNilAuthenticator = errors.New("expecting a non-nil admin authenticator")
+)
+
+// New returns the admin handler wrapped in authmid.Middleware.
+// Mount it with http.StripPrefix if it isn't served at the root.
+func New(cfg *Config) (http.Handler, error) {
+	if cfg == nil {
+		return nil, errNilConfig
+	}
+	if cfg.Backend == nil {
+		return nil, errNilBackend
+	}
+	if cfg.Authenticator == nil {
+		return nil, errNilAuthenticator
+	}
+	h := &handler{
+		backend:     cfg.Backend,
+		provisioner: &authmid.Provisioner{Backend: cfg.Backend, KeyPrefix: cfg.KeyPrefix},
+	}
+	return authmid.Middleware(cfg.Authenticator, h), nil
+}
+
+type handler struct {
+	backend     authmid.Backend
+	provisioner *authmid.Provisioner
+}
+
+type credentials struct {
+	APIKey string `json:"api_key"`
+	Secret string `json:"secret,omitempty"`
+}
+
+type keyList struct {
+	APIKeys    []string `json:"api_keys"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Split the escaped path so that keys may contain escaped slashes.
+	path := strings.Trim(r.URL.EscapedPath(), "/")
+	if path != "keys" && !strings.HasPrefix(path, "keys/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	segments := strings.Split(path, "/")
+	switch len(segments) {
+	case 1:
+		switch r.Method {
+		case "POST":
+			h.create(w, r)
+		case "GET":
+			h.list(w, r)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+		return
+
+	case 2, 3:
+		apiKey, err := url.PathUnescape(segments[1])
+		if err != nil || apiKey == "" {
+			writeError(w, http.StatusBadRequest, "invalid API key")
+			return
+		}
+		if len(segments) == 2 {
+			if r.Method != "DELETE" {
+				methodNotAllowed(w, "DELETE")
+				return
+			}
+			h.delete(w, apiKey)
+			return
+		}
+		if r.Method != "POST" {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		switch segments[2] {
+		case "rotate":
+			h.rotate(w, apiKey, true)
+			return
+		case "disable":
+			h.rotate(w, apiKey, false)
+			return
+		}
+	}
+
+	writeError(w, http.StatusNotFound, "not found")
+}
+
+func (h *handler) create(w http.ResponseWriter, r *http.Request) {
+	creds, err := h.provisioner.Provision()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, &credentials{APIKey: creds.APIKey, Secret: creds.Secret})
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	lister, ok := h.backend.(authmid.Lister)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "the backend does not support listing keys")
+		return
+	}
+	qv := r.URL.Query()
+	limit := 0
+	if s := qv.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "expecting a non-negative integer limit")
+			return
+		}
+		limit = n
+	}
+	keys, nextCursor, err := lister.ListAPIKeys(qv.Get("cursor"), limit)
+	if errors.Is(err, errors.ErrUnsupported) {
+		// Wrappers such as authmid.InstrumentBackend list only if they wrap a Lister.
+		writeError(w, http.StatusNotImplemented, "the backend does not support listing keys")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	writeJSON(w, http.StatusOK, &keyList{APIKeys: keys, NextCursor: nextCursor})
+}
+
+// rotate replaces the secret of an existing key, only
+// revealing the new secret if reveal is set.
+func (h *handler) rotate(w http.ResponseWriter, apiKey string, reveal bool) {
+	if !h.exists(w, apiKey) {
+		return
+	}
+	secret, err := authmid.GenerateSecret()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := h.backend.UpsertSecret(apiKey, secret); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !reveal {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, &credentials{APIKey: apiKey, Secret: secret})
+}
+
+func (h *handler) delete(w http.ResponseWriter, apiKey string) {
+	if !h.exists(w, apiKey) {
+		return
+	}
+	if err := h.backend.DeleteAPIKey(apiKey); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// exists reports whether apiKey exists, otherwise it writes
+// 404 or, if the backend failed, 500.
+func (h *handler) exists(w http.ResponseWriter, apiKey string) bool {
+	_, err := h.backend.LookupSecret(apiKey)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, authmid.ErrNoSuchAPIKey):
+		writeError(w, http.StatusNotFound, "no such API key")
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+	return false
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, &errorResponse{Error: msg})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	// Responses may carry secrets.
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
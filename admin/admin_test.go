@@ -0,0 +1,195 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/admin"
+	"github.com/orijtech/authmid/backend/memory"
+)
+
+const (
+	adminKey    = "admin"
+	adminSecret = "admin-secret"
+)
+
+type adminAuth struct{}
+
+var _ authmid.Authenticator = adminAuth{}
+
+func (adminAuth) HeaderValues(http.Header) ([]string, []string, error) { return nil, nil, nil }
+func (adminAuth) LookupAPIKey(hdr http.Header) (string, error)         { return hdr.Get("ADMIN-KEY"), nil }
+func (adminAuth) Signature(hdr http.Header) (string, error)            { return hdr.Get("ADMIN-SIGN"), nil }
+
+func (adminAuth) LookupSecret(apiKey string) ([]byte, error) {
+	if apiKey != adminKey {
+		return nil, authmid.ErrNoSuchAPIKey
+	}
+	return []byte(adminSecret), nil
+}
+
+func do(h http.Handler, method, target string, signed bool) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("ADMIN-KEY", adminKey)
+	if signed {
+		urlPath := req.URL.Path
+		if q := req.URL.Query(); len(q) > 0 {
+			urlPath += "?" + q.Encode()
+		}
+		mac := hmac.New(sha256.New, []byte(adminSecret))
+		mac.Write([]byte(method + urlPath))
+		req.Header.Set("ADMIN-SIGN", fmt.Sprintf("%x", mac.Sum(nil)))
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var body map[string]interface{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec, body
+}
+
+func TestKeyLifecycle(t *testing.T) {
+	backend, _ := memory.NewWithMap(make(map[string]string))
+	h, err := admin.New(&admin.Config{Backend: backend, Authenticator: adminAuth{}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if rec, _ := do(h, "POST", "/keys", false); rec.Code == http.StatusCreated {
+		t.Fatalf("unsigned request was accepted")
+	}
+
+	rec, body := do(h, "POST", "/keys", true)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: got %d want %d; body: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	apiKey, _ := body["api_key"].(string)
+	secret, _ := body["secret"].(string)
+	if err := authmid.ValidateAPIKey(apiKey); err != nil {
+		t.Errorf("created key %q: %v", apiKey, err)
+	}
+	if got, _ := backend.LookupSecret(apiKey); string(got) != secret {
+		t.Errorf("stored secret: got %q want %q", got, secret)
+	}
+
+	rec, body = do(h, "GET", "/keys?limit=10", true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: got %d want %d", rec.Code, http.StatusOK)
+	}
+	if keys, _ := body["api_keys"].([]interface{}); len(keys) != 1 || keys[0] != apiKey {
+		t.Errorf("list: got %v want [%s]", body["api_keys"], apiKey)
+	}
+
+	rec, body = do(h, "POST", "/keys/"+apiKey+"/rotate", true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rotate: got %d want %d", rec.Code, http.StatusOK)
+	}
+	rotated, _ := body["secret"].(string)
+	if rotated == "" || rotated == secret {
+		t.Errorf("rotate: expected a fresh secret, got %q", rotated)
+	}
+	if got, _ := backend.LookupSecret(apiKey); string(got) != rotated {
+		t.Errorf("rotated secret: got %q want %q", got, rotated)
+	}
+
+	rec, _ = do(h, "POST", "/keys/"+apiKey+"/disable", true)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("disable: got %d want %d", rec.Code, http.StatusNoContent)
+	}
+	if got, _ := backend.LookupSecret(apiKey); string(got) == rotated || len(got) == 0 {
+		t.Errorf("disable: expected an unrevealed replacement secret")
+	}
+
+	rec, _ = do(h, "DELETE", "/keys/"+apiKey, true)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: got %d want %d", rec.Code, http.StatusNoContent)
+	}
+	if _, err := backend.LookupSecret(apiKey); err == nil {
+		t.Errorf("delete: key still present")
+	}
+
+	tests := [...]struct {
+		method, target string
+		wantCode       int
+	}{
+		0: {"DELETE", "/keys/" + apiKey, http.StatusNotFound},
+		1: {"POST", "/keys/" + apiKey + "/rotate", http.StatusNotFound},
+		2: {"PUT", "/keys", http.StatusMethodNotAllowed},
+		3: {"GET", "/keys/" + apiKey + "/rotate", http.StatusMethodNotAllowed},
+		4: {"GET", "/keys?limit=-1", http.StatusBadRequest},
+		5: {"GET", "/other", http.StatusNotFound},
+	}
+	for i, tt := range tests {
+		if rec, _ := do(h, tt.method, tt.target, true); rec.Code != tt.wantCode {
+			t.Errorf("#%d: %s %s: got %d want %d", i, tt.method, tt.target, rec.Code, tt.wantCode)
+		}
+	}
+}
+
+func TestEscapedKeys(t *testing.T) {
+	backend, _ := memory.NewWithMap(map[string]string{"50%off": "s1", "team/a": "s2", "team": "s3"})
+	h, _ := admin.New(&admin.Config{Backend: backend, Authenticator: adminAuth{}})
+
+	tests := [...]struct {
+		target, apiKey string
+	}{
+		0: {"/keys/50%25off", "50%off"},
+		1: {"/keys/team%2Fa", "team/a"},
+	}
+	for i, tt := range tests {
+		rec, body := do(h, "POST", tt.target+"/rotate", true)
+		if rec.Code != http.StatusOK || body["api_key"] != tt.apiKey {
+			t.Errorf("#%d: rotate: got %d %v want 200 for %q", i, rec.Code, body["api_key"], tt.apiKey)
+		}
+		if rec, _ := do(h, "DELETE", tt.target, true); rec.Code != http.StatusNoContent {
+			t.Errorf("#%d: delete: got %d want %d", i, rec.Code, http.StatusNoContent)
+		}
+		if _, err := backend.LookupSecret(tt.apiKey); err == nil {
+			t.Errorf("#%d: %q wasn't deleted", i, tt.apiKey)
+		}
+	}
+	if got, _ := backend.LookupSecret("team"); string(got) != "s3" {
+		t.Errorf("another key was modified, got %q", got)
+	}
+}
+
+func TestBackendOutage(t *testing.T) {
+	h, _ := admin.New(&admin.Config{Backend: unavailableBackend{}, Authenticator: adminAuth{}})
+	for _, tt := range []struct{ method, target string }{
+		{"POST", "/keys/k/rotate"},
+		{"DELETE", "/keys/k"},
+	} {
+		if rec, _ := do(h, tt.method, tt.target, true); rec.Code != http.StatusInternalServerError {
+			t.Errorf("%s %s: got %d want %d", tt.method, tt.target, rec.Code, http.StatusInternalServerError)
+		}
+	}
+}
+
+type unavailableBackend struct{}
+
+var errUnavailable = errors.New("unavailable")
+
+func (unavailableBackend) LookupSecret(string) ([]byte, error) { return nil, errUnavailable }
+func (unavailableBackend) UpsertSecret(string, string) error   { return errUnavailable }
+func (unavailableBackend) DeleteAPIKey(string) error           { return errUnavailable }
+func (unavailableBackend) Close() error                        { return nil }
@@ -0,0 +1,218 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Outcome string
+
+const (
+	Allowed Outcome = "allowed"
+	Denied  Outcome = "denied"
+)
+
+// AuditEvent records one authentication decision. It never holds secrets.
+type AuditEvent struct {
+	Time    time.Time     `json:"time"`
+	Outcome Outcome       `json:"outcome"`
+	Reason  string        `json:"reason,omitempty"`
+	Status  int           `json:"status,omitempty"`
+	APIKey  string        `json:"api_key,omitempty"`
+	IP      string        `json:"ip,omitempty"`
+	Method  string        `json:"method"`
+	Path    string        `json:"path"`
+	Latency time.Duration `json:"latency_ns"`
+
+	// Err is why the request was denied, for sinks to classify
+	// with errors.Is and errors.As rather than by Reason.
+	Err error `json:"-"`
+}
+
+type AuditSink interface {
+	Audit(ev *AuditEvent)
+}
+
+type AuditConfig struct {
+	Sink AuditSink
+
+	// ClientIP if set extracts the client IP, by default RemoteIP.
+	ClientIP func(*http.Request) string
+}
+
+var (
+	errNilAuditConfig = errors.New("expecting a non-nil audit config")
+	errNilAuditSink   = errors.New("expecting a non-nil audit sink")
+)
+
+// Audit sends the decisions that the Middlewares within next make about
+// each request to cfg.Sink as they're made, before the request is passed
+// on. Requests without a decision aren't audited. Nested Audits each
+// receive the decisions, to combine sinks use MultiSink.
+func Audit(cfg *AuditConfig, next http.Handler) (http.Handler, error) {
+	if cfg == nil {
+		return nil, errNilAuditConfig
+	}
+	if cfg.Sink == nil {
+		return nil, errNilAuditSink
+	}
+	acfg := *cfg
+	if acfg.ClientIP == nil {
+		acfg.ClientIP = RemoteIP
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auditors, _ := r.Context().Value(auditKey{}).([]*auditor)
+		// A copy, so that siblings don't share the backing array.
+		auditors = append(auditors[:len(auditors):len(auditors)], &auditor{cfg: &acfg})
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), auditKey{}, auditors)))
+	}), nil
+}
+
+type auditKey struct{}
+
+// auditor is an Audit's state for one request.
+type auditor struct {
+	cfg    *AuditConfig
+	apiKey string
+}
+
+func auditorsFromContext(ctx context.Context) []*auditor {
+	auditors, _ := ctx.Value(auditKey{}).([]*auditor)
+	return auditors
+}
+
+// recordAPIKey notes the API key that req claims, for the audit and
+// trace of failures too.
+func recordAPIKey(req *http.Request, apiKey string) {
+	for _, a := range auditorsFromContext(req.Context()) {
+		a.apiKey = apiKey
+	}
+	trace.SpanFromContext(req.Context()).SetAttributes(attribute.String(AttrAPIKey, apiKey))
+}
+
+// recordDecision sends the decision about req to the sinks of the Audits
+// that req passed through.
+func recordDecision(req *http.Request, start time.Time, status int, err error) {
+	for _, a := range auditorsFromContext(req.Context()) {
+		ev := &AuditEvent{
+			Time:    start,
+			Latency: time.Since(start),
+			Outcome: Allowed,
+			Status:  status,
+			APIKey:  a.apiKey,
+			IP:      a.cfg.ClientIP(req),
+			Method:  req.Method,
+			Path:    req.URL.Path,
+		}
+		if err != nil {
+			ev.Outcome = Denied
+			ev.Reason = failureReason(err)
+			ev.Err = err
+		}
+		a.cfg.Sink.Audit(ev)
+	}
+}
+
+// failureReason is err's message, less the canonical
+// request that SignatureDebuggers attach to mismatches.
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrSignatureMismatch):
+		return ErrSignatureMismatch.Error()
+	case errors.Is(err, ErrNoSuchAPIKey):
+		return ErrNoSuchAPIKey.Error()
+	default:
+		return err.Error()
+	}
+}
+
+type multiSink []AuditSink
+
+// MultiSink sends every event to each of sinks in turn, e.g. to both
+// log decisions and count them.
+func MultiSink(sinks ...AuditSink) AuditSink {
+	return multiSink(append([]AuditSink(nil), sinks...))
+}
+
+func (ms multiSink) Audit(ev *AuditEvent) {
+	for _, sink := range ms {
+		sink.Audit(ev)
+	}
+}
+
+type slogSink struct {
+	logger *slog.Logger
+}
+
+// NewSlogSink logs events to logger at Info, or Warn for denials.
+// A nil logger uses slog.Default.
+func NewSlogSink(logger *slog.Logger) AuditSink {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &slogSink{logger: logger}
+}
+
+func (ss *slogSink) Audit(ev *AuditEvent) {
+	level := slog.LevelInfo
+	if ev.Outcome == Denied {
+		level = slog.LevelWarn
+	}
+	ss.logger.LogAttrs(context.Background(), level, "authmid decision",
+		slog.String("outcome", string(ev.Outcome)),
+		slog.String("reason", ev.Reason),
+		slog.Int("status", ev.Status),
+		slog.String("api_key", ev.APIKey),
+		slog.String("ip", ev.IP),
+		slog.String("method", ev.Method),
+		slog.String("path", ev.Path),
+		slog.Duration("latency", ev.Latency),
+	)
+}
+
+type jsonLinesSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+
+	// Errors are reported to onError, if set.
+	onError func(error)
+}
+
+// NewJSONLinesSink writes each event to w as a line of JSON, e.g. to a
+// file opened with os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600).
+// Write errors are passed to onError if it is non-nil.
+func NewJSONLinesSink(w io.Writer, onError func(error)) AuditSink {
+	return &jsonLinesSink{enc: json.NewEncoder(w), onError: onError}
+}
+
+func (js *jsonLinesSink) Audit(ev *AuditEvent) {
+	js.mu.Lock()
+	err := js.enc.Encode(ev)
+	js.mu.Unlock()
+	if err != nil && js.onError != nil {
+		js.onError(err)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/orijtech/authmid"
+)
+
+func TestAudit(t *testing.T) {
+	ha := newHeaderAuthenticator()
+	buf := new(bytes.Buffer)
+	slogBuf := new(bytes.Buffer)
+	sinks := authmid.MultiSink(
+		authmid.NewJSONLinesSink(buf, func(err error) { t.Errorf("JSON lines sink: %v", err) }),
+		authmid.NewSlogSink(slog.New(slog.NewJSONHandler(slogBuf, nil))),
+	)
+	handler, err := authmid.Audit(&authmid.AuditConfig{Sink: sinks}, authmid.Middleware(ha, http.NotFoundHandler()))
+	if err != nil {
+		t.Fatalf("Audit: %v", err)
+	}
+
+	tests := [...]struct {
+		secret []byte
+		want   authmid.AuditEvent
+	}{
+		0: {secret: bAPISecret1, want: authmid.AuditEvent{Outcome: authmid.Allowed}},
+		1: {secret: bAPISecret2, want: authmid.AuditEvent{Outcome: authmid.Denied, Status: http.StatusBadRequest, Reason: authmid.ErrSignatureMismatch.Error()}},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest("DELETE", "https://orijtech.com/v1/items/1", nil)
+		req.Header.Set("TEST-ACCESS-TIMESTAMP", "1500000000")
+		if err := ha.SignRequest(req, apiKey1, tt.secret); err != nil {
+			t.Fatalf("#%d: SignRequest: %v", i, err)
+		}
+		buf.Reset()
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		var got authmid.AuditEvent
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Errorf("#%d: %v; line: %s", i, err, buf.Bytes())
+			continue
+		}
+		if got.Outcome != tt.want.Outcome || got.Status != tt.want.Status || got.Reason != tt.want.Reason {
+			t.Errorf("#%d: got %+v want %+v", i, got, tt.want)
+		}
+		if got.APIKey != apiKey1 || got.IP != "192.0.2.1" || got.Method != "DELETE" || got.Path != "/v1/items/1" || got.Time.IsZero() {
+			t.Errorf("#%d: got %+v", i, got)
+		}
+	}
+
+	if lines := strings.Count(slogBuf.String(), "\n"); lines != len(tests) {
+		t.Errorf("got %d slog lines want %d", lines, len(tests))
+	}
+	for _, secret := range [][]byte{bAPISecret1, bAPISecret2} {
+		if bytes.Contains(slogBuf.Bytes(), secret) {
+			t.Errorf("secret %s leaked into the audit log", secret)
+		}
+	}
+}
+
+type eventLog []*authmid.AuditEvent
+
+func (el *eventLog) Audit(ev *authmid.AuditEvent) {
+	*el = append(*el, ev)
+}
+
+func TestAuditAtDecisionTime(t *testing.T) {
+	ha := newHeaderAuthenticator()
+	outer, inner := new(eventLog), new(eventLog)
+	panicking := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		if len(*outer) != 1 || len(*inner) != 1 {
+			t.Errorf("got %d and %d events before the handler ran, want 1 each", len(*outer), len(*inner))
+		}
+		panic(http.ErrAbortHandler)
+	})
+	handler, _ := authmid.Audit(&authmid.AuditConfig{Sink: inner}, authmid.Middleware(ha, panicking))
+	handler, _ = authmid.Audit(&authmid.AuditConfig{Sink: outer}, handler)
+
+	req := httptest.NewRequest("GET", "https://orijtech.com/v1/items/1", nil)
+	req.Header.Set("TEST-ACCESS-TIMESTAMP", "1500000000")
+	if err := ha.SignRequest(req, apiKey1, bAPISecret1); err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+	func() {
+		defer func() { recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	for name, log := range map[string]*eventLog{"outer": outer, "inner": inner} {
+		if len(*log) != 1 || (*log)[0].Outcome != authmid.Allowed || (*log)[0].APIKey != apiKey1 {
+			t.Errorf("%s: got %+v", name, *log)
+		}
+	}
+}
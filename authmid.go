@@ -15,6 +15,8 @@
 package authmid
 
 import (
+	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"errors"
@@ -22,7 +24,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
-	"strings"
+	"time"
 )
 
 type Authenticator interface {
@@ -40,6 +42,21 @@ type ReadOnlyBackend interface {
 	LookupSecret(apiKey string) ([]byte, error)
 }
 
+// ContextBackend is optionally implemented by backends, and Authenticators,
+// whose lookups take the request's context, e.g. for cancellation and tracing.
+// Checker prefers it to LookupSecret.
+type ContextBackend interface {
+	LookupSecretContext(ctx context.Context, apiKey string) ([]byte, error)
+}
+
+// LookupSecretContext uses b's LookupSecretContext if it is a ContextBackend.
+func LookupSecretContext(ctx context.Context, b ReadOnlyBackend, apiKey string) ([]byte, error) {
+	if cb, ok := b.(ContextBackend); ok {
+		return cb.LookupSecretContext(ctx, apiKey)
+	}
+	return b.LookupSecret(apiKey)
+}
+
 type WriteBackend interface {
 	UpsertSecret(apiKey, apiSecret string) error
 	DeleteAPIKey(apiKey string) error
@@ -51,38 +68,131 @@ type Backend interface {
 	Close() error
 }
 
+// Lister is optionally implemented by backends that can enumerate
+// their API keys. Pass an empty cursor to start from the beginning and
+// the returned nextCursor to resume, an empty nextCursor signals that
+// there are no more keys. A limit <= 0 places no bound on the page size.
+type Lister interface {
+	ListAPIKeys(cursor string, limit int) (apiKeys []string, nextCursor string, err error)
+}
+
 var (
 	ErrNoSuchAPIKey      = errors.New("no such apiKey found")
 	ErrEmptyTableName    = errors.New("expecting a non-empty table name")
 	ErrSignatureMismatch = errors.New("invalid/mismatched signatures")
 )
 
+// Middleware verifies requests with vf before passing them on to next,
+// with the authenticated Principal in their context.
 func Middleware(vf Authenticator, next http.Handler) http.Handler {
-	return &auther{verify: Checker(vf), next: next}
+	return &auther{authenticate: authenticator(vf), next: next}
+}
+
+// CheckerMiddleware is like Middleware but for any verification function,
+// its errors are rendered in the same way. No Principal is attached.
+func CheckerMiddleware(check func(*http.Request) error, next http.Handler) http.Handler {
+	authenticate := func(req *http.Request) (*Principal, error) {
+		return nil, check(req)
+	}
+	return &auther{authenticate: authenticate, next: next}
 }
 
 type auther struct {
-	verify func(*http.Request) error
-	next   http.Handler
+	authenticate func(*http.Request) (*Principal, error)
+	next         http.Handler
 }
 
 var _ http.Handler = (*auther)(nil)
 
 func (a *auther) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	err := a.verify(r)
+	start := time.Now()
+	ctx, span := tracer().Start(r.Context(), "authmid.authenticate")
+	ar := r.WithContext(ctx)
+	principal, err := a.authenticate(ar)
+	// Authenticating replaces the body it reads, on the copy.
+	r.Body = ar.Body
+	recordSpan(span, err)
+	span.End()
 	if err == nil {
 		// We can proceed, verification was successful.
+		recordDecision(r, start, 0, nil)
+		if principal != nil {
+			r = r.WithContext(ContextWithPrincipal(r.Context(), principal))
+		}
 		a.next.ServeHTTP(w, r)
 		return
 	}
 
 	// Otherwise we've encountered an error
-	switch typ := err.(type) {
-	case CodedError:
-		http.Error(w, typ.Error(), typ.Code())
-	default:
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	recordDecision(r, start, ErrorStatus(err), err)
+	WriteError(w, err)
+}
+
+// InternalError is a failure of the server rather than of the request,
+// such as of a backend. Middleware answers it with a bare 500, its details
+// are only for logs and audit sinks.
+type InternalError struct {
+	Err error
+}
+
+func (ie *InternalError) Error() string {
+	return ie.Err.Error()
+}
+
+func (ie *InternalError) Unwrap() error {
+	return ie.Err
+}
+
+// ErrorStatus is the HTTP status that Middleware responds to err with.
+func ErrorStatus(err error) int {
+	var ie *InternalError
+	if errors.As(err, &ie) {
+		return http.StatusInternalServerError
+	}
+	if typ, ok := err.(CodedError); ok {
+		return typ.Code()
+	}
+	return http.StatusBadRequest
+}
+
+// ErrorMessage is what Middleware tells clients of err,
+// which is err's text unless it's an InternalError.
+func ErrorMessage(err error) string {
+	var ie *InternalError
+	if errors.As(err, &ie) {
+		return http.StatusText(http.StatusInternalServerError)
+	}
+	return err.Error()
+}
+
+// WriteError renders err as Middleware does, for adapters to other frameworks.
+func WriteError(w http.ResponseWriter, err error) {
+	if le, ok := err.(*LockedOutError); ok {
+		w.Header().Set("Retry-After", RetryAfter(le.RetryAfter))
+	}
+	http.Error(w, ErrorMessage(err), ErrorStatus(err))
+}
+
+func authenticator(vf Authenticator) func(*http.Request) (*Principal, error) {
+	return func(req *http.Request) (*Principal, error) {
+		return Authenticate(vf, req)
+	}
+}
+
+// Authenticate verifies req with Checker's rules and then
+// looks up the Principal of the authenticated API key.
+func Authenticate(vf Authenticator, req *http.Request) (*Principal, error) {
+	apiKey, err := check(vf, req)
+	if err != nil {
+		return nil, err
+	}
+	principal := &Principal{APIKey: apiKey}
+	if sb, ok := vf.(ScopeBackend); ok {
+		if principal.Scopes, err = sb.LookupScopes(apiKey); err != nil {
+			return nil, err
+		}
 	}
+	return principal, nil
 }
 
 var errNilHeader = errors.New("expecting a non-nil header")
@@ -93,51 +203,72 @@ type ExcludeMethodAndPather interface {
 
 func Checker(vf Authenticator) func(*http.Request) error {
 	return func(req *http.Request) error {
-		if req == nil || len(req.Header) == 0 {
-			return errNilHeader
-		}
-		wantSignature, err := vf.Signature(req.Header)
-		if err != nil {
-			return err
-		}
-		apiKey, err := vf.LookupAPIKey(req.Header)
-		if err != nil {
-			return err
-		}
-		apiSecret, err := vf.LookupSecret(apiKey)
-		if err != nil {
-			return err
-		}
-		rreq, body, err := slurpThenRecoverBody(req)
-		if err != nil {
-			return err
-		}
-		mac := hmac.New(sha256.New, apiSecret)
-		inputs := []string{string(body)}
-		if ex, ok := vf.(ExcludeMethodAndPather); !ok || !ex.ExcludeMethodAndPath() {
-			urlPath := rreq.URL.Path
-			if q := req.URL.Query(); len(q) > 0 {
-				urlPath += "?" + q.Encode()
-			}
-			// Otherwise prepend req.Method and urlPath
-			inputs = append([]string{req.Method, urlPath}, inputs...)
-		}
-		headerValues, warnings, err := vf.HeaderValues(req.Header)
-		if err != nil {
-			return err
-		}
-		if len(warnings) > 0 {
-			// TODO: Figure out if to send this component in the
-			// response writer and when should the write be performed?
-		}
-		sigInput := append(headerValues, inputs...)
-		_, _ = io.WriteString(mac, strings.Join(sigInput, ""))
-		gotSignature := fmt.Sprintf("%x", mac.Sum(nil))
-		if gotSignature != wantSignature {
-			return ErrSignatureMismatch
-		}
-		return nil
+		_, err := check(vf, req)
+		return err
+	}
+}
+
+// check verifies req and returns its API key.
+func check(vf Authenticator, req *http.Request) (string, error) {
+	if req == nil || len(req.Header) == 0 {
+		return "", errNilHeader
+	}
+	wantSignature, err := vf.Signature(req.Header)
+	if err != nil {
+		return "", err
+	}
+	apiKey, err := vf.LookupAPIKey(req.Header)
+	if err != nil {
+		return "", err
+	}
+	recordAPIKey(req, apiKey)
+	apiSecret, err := LookupSecretContext(req.Context(), vf, apiKey)
+	if err != nil {
+		return "", err
+	}
+	rreq, body, err := slurpThenRecoverBody(req)
+	if err != nil {
+		return "", err
+	}
+	headerValues, warnings, err := vf.HeaderValues(req.Header)
+	if err != nil {
+		return "", err
 	}
+	if len(warnings) > 0 {
+		// TODO: Figure out if to send this component in the
+		// response writer and when should the write be performed?
+	}
+	cr := canonicalize(rreq, body, headerValues, CanonicalizationOf(vf))
+	gotSignature := hmacHex(apiSecret, cr.signatureInput())
+	// Constant time so that timing doesn't reveal how much of a guess matched.
+	if !hmac.Equal([]byte(gotSignature), []byte(wantSignature)) {
+		return "", signatureMismatch(vf, req, apiKey, apiSecret, cr)
+	}
+	return apiKey, nil
+}
+
+// Sign returns the signature that Checker expects for req, where headerValues
+// are the values that the verifying Authenticator's HeaderValues returns and
+// c is its canonicalization, see CanonicalizationOf.
+// The request body, if any, is read and then restored.
+func Sign(secret []byte, req *http.Request, headerValues []string, c Canonicalization) (string, error) {
+	if req == nil || req.URL == nil {
+		return "", errNilRequest
+	}
+	rreq, body, err := slurpThenRecoverBody(req)
+	if err != nil {
+		return "", err
+	}
+	cr := canonicalize(rreq, body, headerValues, c)
+	return hmacHex(secret, cr.signatureInput()), nil
+}
+
+var errNilRequest = errors.New("expecting a non-nil request and URL")
+
+func hmacHex(secret []byte, input string) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = io.WriteString(mac, input)
+	return fmt.Sprintf("%x", mac.Sum(nil))
 }
 
 type CodedError interface {
@@ -145,6 +276,12 @@ type CodedError interface {
 	Code() int
 }
 
+// ReadBody reads req's body and restores it so that it can be read again.
+func ReadBody(req *http.Request) ([]byte, error) {
+	_, body, err := slurpThenRecoverBody(req)
+	return body, err
+}
+
 func slurpThenRecoverBody(req *http.Request) (*http.Request, []byte, error) {
 	if req.Body == nil {
 		return req, nil, nil
@@ -156,11 +293,8 @@ func slurpThenRecoverBody(req *http.Request) (*http.Request, []byte, error) {
 	}
 	// Close the original body
 	_ = req.Body.Close()
-	prc, pwc := io.Pipe()
-	go func() {
-		defer pwc.Close()
-		pwc.Write(body)
-	}()
-	req.Body = prc
+	// A reader over body rather than a pipe, whose writing
+	// goroutine would leak if the body weren't read again.
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
 	return req, body, nil
 }
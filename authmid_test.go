@@ -15,6 +15,7 @@
 package authmid_test
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
@@ -85,6 +86,92 @@ func TestChecker(t *testing.T) {
 	}
 }
 
+func TestSign(t *testing.T) {
+	tests := [...]struct {
+		method, target string
+		body           []byte
+	}{
+		0: {method: "POST", target: "https://orijtech.com/v1/orders", body: []byte(`{"id": 1}`)},
+		1: {method: "GET", target: "https://orijtech.com/v1/orders?page=2&limit=10"},
+		2: {method: "DELETE", target: "https://orijtech.com/"},
+	}
+
+	for i, tt := range tests {
+		u, _ := url.Parse(tt.target)
+		req := &http.Request{Method: tt.method, URL: u, Header: make(http.Header)}
+		if len(tt.body) > 0 {
+			req.Body = ioutil.NopCloser(bytes.NewReader(tt.body))
+		}
+		timestamp := fmt.Sprintf("%d", time.Now().Unix())
+		req.Header.Set("TEST-ACCESS-TIMESTAMP", timestamp)
+		req.Header.Set("TEST-ACCESS-KEY", apiKey1)
+		signature, err := authmid.Sign(bAPISecret1, req, []string{timestamp}, authmid.Canonicalization{})
+		if err != nil {
+			t.Errorf("#%d: Sign: %v", i, err)
+			continue
+		}
+		req.Header.Set("TEST-ACCESS-SIGN", signature)
+		if err := authmid.Checker(&sampleAuthChecker{})(req); err != nil {
+			t.Errorf("#%d: Checker rejected the signed request: %v", i, err)
+		}
+		if req.Body == nil {
+			continue
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		if !bytes.Equal(body, tt.body) {
+			t.Errorf("#%d: body was not restored: got %q want %q", i, body, tt.body)
+		}
+	}
+}
+
+type canonicalAuthChecker struct {
+	sampleAuthChecker
+	c authmid.Canonicalization
+}
+
+func (ca *canonicalAuthChecker) Canonicalization() authmid.Canonicalization {
+	return ca.c
+}
+
+func TestCanonicalizationPolicies(t *testing.T) {
+	rawAndEscaped := authmid.Canonicalization{Path: authmid.EscapedPath, Query: authmid.RawQuery}
+	tests := [...]struct {
+		// signedPath is what the client signed for the request to target.
+		target, signedPath string
+		c                  authmid.Canonicalization
+		wantErr            bool
+	}{
+		0: {target: "/search?q=a+b&a=1", signedPath: "/search?a=1&q=a+b"},
+		1: {target: "/search?q=a+b&a=1", signedPath: "/search?q=a+b&a=1", wantErr: true},
+		2: {target: "/search?q=a+b&a=1", signedPath: "/search?q=a+b&a=1", c: rawAndEscaped},
+		3: {target: "/search?q=a%20b", signedPath: "/search?q=a%20b", c: rawAndEscaped},
+		4: {target: "/search?q=a%20b", signedPath: "/search?q=a+b"},
+		5: {target: "/files/a%2Fb", signedPath: "/files/a/b"},
+		6: {target: "/files/a%2Fb", signedPath: "/files/a%2Fb", wantErr: true},
+		7: {target: "/files/a%2Fb", signedPath: "/files/a%2Fb", c: rawAndEscaped},
+		8: {target: "/files/a%2Fb", signedPath: "/files/a/b", c: rawAndEscaped, wantErr: true},
+		9: {target: "/files/x?b=2&a=1", signedPath: "/files/x?b=2&a=1", c: authmid.Canonicalization{Query: authmid.RawQuery}},
+	}
+
+	for i, tt := range tests {
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader("GET " + tt.target + " HTTP/1.1\r\nHost: orijtech.com\r\n\r\n")))
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		timestamp := "1500000000"
+		mac := hmac.New(sha256.New, bAPISecret1)
+		mac.Write([]byte(timestamp + "GET" + tt.signedPath))
+		req.Header.Set("TEST-ACCESS-TIMESTAMP", timestamp)
+		req.Header.Set("TEST-ACCESS-KEY", apiKey1)
+		req.Header.Set("TEST-ACCESS-SIGN", fmt.Sprintf("%x", mac.Sum(nil)))
+
+		err = authmid.Checker(&canonicalAuthChecker{c: tt.c})(req)
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("#%d: gotErr=%v wantErr=%v; err:(%v)", i, gotErr, tt.wantErr, err)
+		}
+	}
+}
+
 type sampleAuthChecker struct {
 	mu sync.Mutex
 	authmid.Backend
@@ -0,0 +1,376 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package chain composes multiple authmid.Backends into one, which is
+// useful for layering caches and for migrating keys between stores.
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/orijtech/authmid"
+)
+
+type Consistency int
+
+const (
+	// WriteAll requires every backend to accept a write.
+	WriteAll Consistency = iota
+
+	// WritePrimary requires only the first backend to accept a write,
+	// failures on the rest are ignored.
+	WritePrimary
+
+	// WriteAny requires at least one backend to accept a write.
+	WriteAny
+
+	// WriteQuorum requires a strict majority of the backends to accept a write.
+	WriteQuorum
+)
+
+func (c Consistency) String() string {
+	switch c {
+	case WriteAll:
+		return "all"
+	case WritePrimary:
+		return "primary"
+	case WriteAny:
+		return "any"
+	case WriteQuorum:
+		return "quorum"
+	default:
+		return fmt.Sprintf("Consistency(%d)", c)
+	}
+}
+
+type Config struct {
+	// Backends are consulted in order on reads, e.g. memory, then Redis, then SQL.
+	Backends []authmid.Backend
+
+	// WriteThrough if set copies a secret found in a later backend
+	// into every backend before it that missed.
+	WriteThrough bool
+
+	Consistency Consistency
+}
+
+type Chain struct {
+	closeOnce    sync.Once
+	backends     []authmid.Backend
+	writeThrough bool
+	consistency  Consistency
+}
+
+var _ authmid.Backend = (*Chain)(nil)
+
+var (
+	errNilConfig          = errors.New("expecting a non-nil config")
+	errNoBackends         = errors.New("expecting at least one backend")
+	errNilBackend         = errors.New("expecting non-nil backends")
+	errUnknownConsistency = errors.New("unknown write consistency")
+	errAlreadyClosed      = errors.New("already closed")
+)
+
+func New(cfg *Config) (*Chain, error) {
+	if cfg == nil {
+		return nil, errNilConfig
+	}
+	if len(cfg.Backends) == 0 {
+		return nil, errNoBackends
+	}
+	for _, b := range cfg.Backends {
+		if b == nil {
+			return nil, errNilBackend
+		}
+	}
+	switch cfg.Consistency {
+	case WriteAll, WritePrimary, WriteAny, WriteQuorum:
+	default:
+		return nil, errUnknownConsistency
+	}
+	c := &Chain{
+		backends:     append([]authmid.Backend(nil), cfg.Backends...),
+		writeThrough: cfg.WriteThrough,
+		consistency:  cfg.Consistency,
+	}
+	return c, nil
+}
+
+var _ authmid.ContextBackend = (*Chain)(nil)
+
+func (c *Chain) LookupSecret(apiKey string) ([]byte, error) {
+	return c.LookupSecretContext(context.Background(), apiKey)
+}
+
+func (c *Chain) LookupSecretContext(ctx context.Context, apiKey string) ([]byte, error) {
+	var errs []error
+	for i, b := range c.backends {
+		secret, err := authmid.LookupSecretContext(ctx, b, apiKey)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if c.writeThrough {
+			for _, missed := range c.backends[:i] {
+				// Best effort: the lookup itself has succeeded.
+				writeThrough(b, missed, apiKey, secret)
+			}
+		}
+		return secret, nil
+	}
+	return nil, combineErrors(errs)
+}
+
+// writeThrough copies apiKey from src into dst, with its scopes and limit
+// if both support them, so that dst answers for it exactly as src did.
+func writeThrough(src, dst authmid.Backend, apiKey string, secret []byte) {
+	if dst.UpsertSecret(apiKey, string(secret)) != nil {
+		return
+	}
+	if sb, ok := src.(authmid.ScopeBackend); ok {
+		if sw, ok := dst.(authmid.ScopeWriter); ok {
+			if scopes, err := sb.LookupScopes(apiKey); err == nil {
+				_ = sw.SetScopes(apiKey, scopes)
+			}
+		}
+	}
+	if lb, ok := src.(authmid.LimitBackend); ok {
+		if lw, ok := dst.(authmid.LimitWriter); ok {
+			if limit, err := lb.LookupLimit(apiKey); err == nil {
+				_ = lw.SetLimit(apiKey, limit)
+			}
+		}
+	}
+}
+
+func (c *Chain) UpsertSecret(apiKey, apiSecret string) error {
+	return c.fanOut(func(b authmid.Backend) error {
+		return b.UpsertSecret(apiKey, apiSecret)
+	})
+}
+
+// DeleteAPIKey deletes apiKey from every backend that holds it, mid
+// migration it's usually held by only some of them. Backends that fail
+// to delete apiKey because they don't hold it count as having accepted
+// the delete, unless none hold it.
+func (c *Chain) DeleteAPIKey(apiKey string) error {
+	var absent int32
+	err := c.fanOut(func(b authmid.Backend) error {
+		err := b.DeleteAPIKey(apiKey)
+		if err != nil && !holds(b, apiKey) {
+			atomic.AddInt32(&absent, 1)
+			return nil
+		}
+		return err
+	})
+	if err == nil && int(absent) == len(c.backends) {
+		return authmid.ErrNoSuchAPIKey
+	}
+	return err
+}
+
+// holds reports whether b may still hold apiKey, only a lookup
+// failing with ErrNoSuchAPIKey rules that out.
+func holds(b authmid.Backend, apiKey string) bool {
+	_, err := b.LookupSecret(apiKey)
+	return !errors.Is(err, authmid.ErrNoSuchAPIKey)
+}
+
+func (c *Chain) fanOut(fn func(authmid.Backend) error) error {
+	return fanOut(c.consistency, c.backends, fn)
+}
+
+// fanOut calls fn with every one of backends at once, and judges the
+// outcome by consistency over those backends only.
+func fanOut(consistency Consistency, backends []authmid.Backend, fn func(authmid.Backend) error) error {
+	errs := make([]error, len(backends))
+	var wg sync.WaitGroup
+	for i, b := range backends {
+		wg.Add(1)
+		go func(i int, b authmid.Backend) {
+			defer wg.Done()
+			errs[i] = fn(b)
+		}(i, b)
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	succeeded := len(backends) - len(failed)
+
+	switch consistency {
+	case WritePrimary:
+		return errs[0]
+	case WriteAny:
+		if succeeded > 0 {
+			return nil
+		}
+	case WriteQuorum:
+		if succeeded > len(backends)/2 {
+			return nil
+		}
+	default:
+		if len(failed) == 0 {
+			return nil
+		}
+	}
+	return combineErrors(failed)
+}
+
+// holder returns the first backend that has a secret for apiKey,
+// the one whose secret LookupSecret returns.
+func (c *Chain) holder(apiKey string) (authmid.Backend, error) {
+	var errs []error
+	for _, b := range c.backends {
+		if _, err := b.LookupSecret(apiKey); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return b, nil
+	}
+	return nil, combineErrors(errs)
+}
+
+// supporting returns the backends for which supports is true.
+func (c *Chain) supporting(supports func(authmid.Backend) bool) []authmid.Backend {
+	var backends []authmid.Backend
+	for _, b := range c.backends {
+		if supports(b) {
+			backends = append(backends, b)
+		}
+	}
+	return backends
+}
+
+var (
+	_ authmid.ScopeBackend = (*Chain)(nil)
+	_ authmid.ScopeWriter  = (*Chain)(nil)
+)
+
+var errScopesUnsupported = errors.New("no backend supports scopes")
+
+// LookupScopes returns the scopes from the first backend that has a
+// secret for apiKey, none if that backend doesn't support scopes.
+func (c *Chain) LookupScopes(apiKey string) ([]string, error) {
+	b, err := c.holder(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	sb, ok := b.(authmid.ScopeBackend)
+	if !ok {
+		return nil, nil
+	}
+	return sb.LookupScopes(apiKey)
+}
+
+// SetScopes fans out to the backends that support scopes,
+// with the chain's write consistency among them.
+func (c *Chain) SetScopes(apiKey string, scopes []string) error {
+	backends := c.supporting(func(b authmid.Backend) bool {
+		_, ok := b.(authmid.ScopeWriter)
+		return ok
+	})
+	if len(backends) == 0 {
+		return errScopesUnsupported
+	}
+	return fanOut(c.consistency, backends, func(b authmid.Backend) error {
+		return b.(authmid.ScopeWriter).SetScopes(apiKey, scopes)
+	})
+}
+
+var (
+	_ authmid.LimitBackend = (*Chain)(nil)
+	_ authmid.LimitWriter  = (*Chain)(nil)
+)
+
+var errLimitsUnsupported = errors.New("no backend supports rate limits")
+
+// LookupLimit returns the limit from the first backend that has a
+// secret for apiKey, no override if that backend doesn't support limits.
+func (c *Chain) LookupLimit(apiKey string) (*authmid.Limit, error) {
+	b, err := c.holder(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	lb, ok := b.(authmid.LimitBackend)
+	if !ok {
+		return nil, nil
+	}
+	return lb.LookupLimit(apiKey)
+}
+
+// SetLimit fans out to the backends that support limits,
+// with the chain's write consistency among them.
+func (c *Chain) SetLimit(apiKey string, limit *authmid.Limit) error {
+	backends := c.supporting(func(b authmid.Backend) bool {
+		_, ok := b.(authmid.LimitWriter)
+		return ok
+	})
+	if len(backends) == 0 {
+		return errLimitsUnsupported
+	}
+	return fanOut(c.consistency, backends, func(b authmid.Backend) error {
+		return b.(authmid.LimitWriter).SetLimit(apiKey, limit)
+	})
+}
+
+func (c *Chain) Close() error {
+	var err error = errAlreadyClosed
+	c.closeOnce.Do(func() {
+		var errs []error
+		for _, b := range c.backends {
+			if cerr := b.Close(); cerr != nil {
+				errs = append(errs, cerr)
+			}
+		}
+		err = combineErrors(errs)
+	})
+	return err
+}
+
+type multiError []error
+
+func (me multiError) Error() string {
+	msgs := make([]string, len(me))
+	for i, err := range me {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap lets errors.Is and errors.As match any of the errors,
+// e.g. ErrNoSuchAPIKey when every backend missed.
+func (me multiError) Unwrap() []error {
+	return me
+}
+
+func combineErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return multiError(errs)
+	}
+}
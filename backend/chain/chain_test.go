@@ -0,0 +1,252 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chain_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/chain"
+	"github.com/orijtech/authmid/backend/memory"
+)
+
+func TestLookupOrderAndWriteThrough(t *testing.T) {
+	cache, _ := memory.NewWithMap(map[string]string{})
+	legacy, _ := memory.NewWithMap(map[string]string{"k1": "from-legacy", "k2": "legacy-only"})
+	primary, _ := memory.NewWithMap(map[string]string{"k1": "from-primary"})
+
+	tests := [...]struct {
+		writeThrough bool
+		apiKey       string
+		want         string
+		wantCached   bool
+	}{
+		0: {apiKey: "k1", want: "from-legacy"},
+		1: {apiKey: "k2", want: "legacy-only"},
+		2: {apiKey: "k2", want: "legacy-only", writeThrough: true, wantCached: true},
+	}
+
+	for i, tt := range tests {
+		c, err := chain.New(&chain.Config{
+			Backends:     []authmid.Backend{cache, legacy, primary},
+			WriteThrough: tt.writeThrough,
+		})
+		if err != nil {
+			t.Fatalf("#%d: New: %v", i, err)
+		}
+		got, err := c.LookupSecret(tt.apiKey)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("#%d: got (%q, %v) want (%q, nil)", i, got, err, tt.want)
+		}
+		_, err = cache.LookupSecret(tt.apiKey)
+		if gotCached := err == nil; gotCached != tt.wantCached {
+			t.Errorf("#%d: gotCached=%v wantCached=%v", i, gotCached, tt.wantCached)
+		}
+	}
+
+	c, _ := chain.New(&chain.Config{Backends: []authmid.Backend{cache, legacy, primary}})
+	if _, err := c.LookupSecret("absent"); err == nil {
+		t.Errorf("expected an error for a key missing from every backend")
+	}
+}
+
+func TestWriteConsistency(t *testing.T) {
+	tests := [...]struct {
+		consistency chain.Consistency
+		failing     []bool
+		wantErr     bool
+	}{
+		0: {consistency: chain.WriteAll, failing: []bool{false, false, false}},
+		1: {consistency: chain.WriteAll, failing: []bool{false, true, false}, wantErr: true},
+		2: {consistency: chain.WritePrimary, failing: []bool{false, true, true}},
+		3: {consistency: chain.WritePrimary, failing: []bool{true, false, false}, wantErr: true},
+		4: {consistency: chain.WriteAny, failing: []bool{true, true, false}},
+		5: {consistency: chain.WriteAny, failing: []bool{true, true, true}, wantErr: true},
+		6: {consistency: chain.WriteQuorum, failing: []bool{false, true, false}},
+		7: {consistency: chain.WriteQuorum, failing: []bool{true, true, false}, wantErr: true},
+	}
+
+	for i, tt := range tests {
+		var backends []authmid.Backend
+		for _, fail := range tt.failing {
+			if fail {
+				backends = append(backends, failingBackend{})
+				continue
+			}
+			m, _ := memory.NewWithMap(map[string]string{})
+			backends = append(backends, m)
+		}
+		c, err := chain.New(&chain.Config{Backends: backends, Consistency: tt.consistency})
+		if err != nil {
+			t.Fatalf("#%d: New: %v", i, err)
+		}
+		err = c.UpsertSecret("k", "s")
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("#%d %v: gotErr=%v wantErr=%v; err:(%v)", i, tt.consistency, gotErr, tt.wantErr, err)
+		}
+		for j, b := range backends {
+			if tt.failing[j] {
+				continue
+			}
+			if got, _ := b.LookupSecret("k"); string(got) != "s" {
+				t.Errorf("#%d: backend #%d did not receive the write", i, j)
+			}
+		}
+	}
+}
+
+var errUnavailable = errors.New("unavailable")
+
+type failingBackend struct{}
+
+func (failingBackend) LookupSecret(string) ([]byte, error) { return nil, errUnavailable }
+func (failingBackend) UpsertSecret(string, string) error   { return errUnavailable }
+func (failingBackend) DeleteAPIKey(string) error           { return errUnavailable }
+func (failingBackend) Close() error                        { return nil }
+
+func TestMissFromEveryBackend(t *testing.T) {
+	first, _ := memory.NewWithMap(map[string]string{})
+	second, _ := memory.NewWithMap(map[string]string{})
+	c, _ := chain.New(&chain.Config{Backends: []authmid.Backend{first, second, failingBackend{}}})
+	if _, err := c.LookupSecret("absent"); !errors.Is(err, authmid.ErrNoSuchAPIKey) || !errors.Is(err, errUnavailable) {
+		t.Errorf("got %v, expecting it to match both ErrNoSuchAPIKey and errUnavailable", err)
+	}
+}
+
+func TestDeleteMidMigration(t *testing.T) {
+	legacy, _ := memory.NewWithMap(map[string]string{"migrated": "s", "legacy": "s"})
+	primary, _ := memory.NewWithMap(map[string]string{"migrated": "s"})
+	c, _ := chain.New(&chain.Config{Backends: []authmid.Backend{strictBackend{primary}, strictBackend{legacy}}, Consistency: chain.WriteAll})
+
+	for _, apiKey := range []string{"migrated", "legacy"} {
+		if err := c.DeleteAPIKey(apiKey); err != nil {
+			t.Errorf("%s: DeleteAPIKey: %v", apiKey, err)
+		}
+		if _, err := c.LookupSecret(apiKey); !errors.Is(err, authmid.ErrNoSuchAPIKey) {
+			t.Errorf("%s: got %v after deleting it", apiKey, err)
+		}
+	}
+	if err := c.DeleteAPIKey("absent"); !errors.Is(err, authmid.ErrNoSuchAPIKey) {
+		t.Errorf("got %v deleting a key that no backend holds", err)
+	}
+}
+
+var errNoRowsAffected = errors.New("no rows were affected")
+
+// strictBackend fails to delete keys it doesn't hold, like the SQL backends.
+type strictBackend struct {
+	*memory.Memory
+}
+
+func (sb strictBackend) DeleteAPIKey(apiKey string) error {
+	if _, err := sb.LookupSecret(apiKey); err != nil {
+		return errNoRowsAffected
+	}
+	return sb.Memory.DeleteAPIKey(apiKey)
+}
+
+func TestWriteThroughScopesAndLimits(t *testing.T) {
+	cache, _ := memory.NewWithMap(map[string]string{})
+	primary, _ := memory.NewWithMap(map[string]string{"k": "s"})
+	primary.SetScopes("k", []string{"orders:read"})
+	primary.SetLimit("k", &authmid.Limit{Rate: 5, Burst: 10})
+	c, _ := chain.New(&chain.Config{Backends: []authmid.Backend{cache, primary}, WriteThrough: true})
+
+	// The first lookup populates the cache, the second is answered by it.
+	for i := 0; i < 2; i++ {
+		if _, err := c.LookupSecret("k"); err != nil {
+			t.Fatalf("#%d: LookupSecret: %v", i, err)
+		}
+		scopes, err := c.LookupScopes("k")
+		if err != nil || len(scopes) != 1 || scopes[0] != "orders:read" {
+			t.Errorf("#%d: got scopes (%v, %v) want [orders:read]", i, scopes, err)
+		}
+		limit, err := c.LookupLimit("k")
+		if err != nil || limit == nil || *limit != (authmid.Limit{Rate: 5, Burst: 10}) {
+			t.Errorf("#%d: got limit (%v, %v)", i, limit, err)
+		}
+	}
+}
+
+// plainBackend holds secrets but neither scopes nor limits.
+type plainBackend struct {
+	authmid.Backend
+}
+
+func TestScopesAndLimitsOfPlainHolder(t *testing.T) {
+	plain, _ := memory.NewWithMap(map[string]string{"k": "s"})
+	other, _ := memory.NewWithMap(map[string]string{})
+
+	for i, backends := range [][]authmid.Backend{
+		{other, plainBackend{plain}},
+		{plainBackend{plain}},
+	} {
+		c, _ := chain.New(&chain.Config{Backends: backends})
+		ha := &authmid.HeaderAuthenticator{Backend: c, KeyHeader: "X-Key", SignatureHeader: "X-Signature"}
+		limited, err := authmid.RateLimit(&authmid.RateLimitConfig{
+			Limiter:   memory.NewRateLimiter(),
+			Default:   authmid.Limit{Rate: 1, Burst: 1},
+			Overrides: c,
+		}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, _ := authmid.PrincipalFromContext(r.Context())
+			if len(p.Scopes) != 0 {
+				t.Errorf("#%d: got scopes %v", i, p.Scopes)
+			}
+		}))
+		if err != nil {
+			t.Fatalf("RateLimit: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		if err := ha.SignRequest(req, "k", []byte("s")); err != nil {
+			t.Fatalf("SignRequest: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		authmid.Middleware(ha, limited).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("#%d: got %d %q want %d", i, rec.Code, rec.Body, http.StatusOK)
+		}
+	}
+}
+
+func TestSetScopesAndLimitsOnSupportingBackends(t *testing.T) {
+	plain, _ := memory.NewWithMap(map[string]string{"k": "s"})
+	scoped, _ := memory.NewWithMap(map[string]string{"k": "s"})
+	c, _ := chain.New(&chain.Config{Backends: []authmid.Backend{plainBackend{plain}, scoped}, Consistency: chain.WriteAll})
+
+	if err := c.SetScopes("k", []string{"orders:read"}); err != nil {
+		t.Errorf("SetScopes: %v", err)
+	}
+	if scopes, _ := scoped.LookupScopes("k"); len(scopes) != 1 {
+		t.Errorf("got scopes %v", scopes)
+	}
+	if err := c.SetLimit("k", &authmid.Limit{Rate: 1, Burst: 1}); err != nil {
+		t.Errorf("SetLimit: %v", err)
+	}
+	if limit, _ := scoped.LookupLimit("k"); limit == nil {
+		t.Errorf("the limit wasn't set")
+	}
+
+	c, _ = chain.New(&chain.Config{Backends: []authmid.Backend{plainBackend{plain}}})
+	if err := c.SetScopes("k", nil); err == nil {
+		t.Errorf("SetScopes: expecting an error without backends that support scopes")
+	}
+	if err := c.SetLimit("k", nil); err == nil {
+		t.Errorf("SetLimit: expecting an error without backends that support limits")
+	}
+}
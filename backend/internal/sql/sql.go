@@ -15,31 +15,65 @@
 package sql
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"strings"
 	"sync"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/orijtech/authmid"
 )
 
+const tracerName = "github.com/orijtech/authmid/backend/sql"
+
 type SQLAuth struct {
 	closeOnce sync.Once
 	tableName string
+	dbType    string
 	db        *sql.DB
+
+	mu sync.Mutex
+	// scopesChecked is whether hasScopesColumn knows if scopesColumn.
+	scopesChecked bool
+	scopesColumn  bool
 }
 
 var _ authmid.Backend = (*SQLAuth)(nil)
 
+var _ authmid.ContextBackend = (*SQLAuth)(nil)
+
 func (m *SQLAuth) LookupSecret(apiKey string) ([]byte, error) {
-	rows, err := m.db.Query("SELECT secret from "+m.tableName+" where api_key=?", apiKey)
+	return m.LookupSecretContext(context.Background(), apiKey)
+}
+
+// LookupSecretContext records the query as a child span of ctx's.
+func (m *SQLAuth) LookupSecretContext(ctx context.Context, apiKey string) (secret []byte, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "sql.SELECT "+m.tableName, trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(
+		attribute.String("db.system", m.dbType),
+		attribute.String("db.sql.table", m.tableName),
+		attribute.String(authmid.AttrAPIKey, apiKey),
+	)
+	defer func() {
+		if err != nil && err != authmid.ErrNoSuchAPIKey {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	rows, err := m.db.QueryContext(ctx, "SELECT secret from "+m.tableName+" where api_key=?", apiKey)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var secret []byte
 		if err := rows.Scan(&secret); err != nil {
 			return nil, err
 		}
@@ -55,6 +89,42 @@ func (m *SQLAuth) LookupSecret(apiKey string) ([]byte, error) {
 
 var errNoRowsAffected = errors.New("no rows were affected")
 
+var _ authmid.Lister = (*SQLAuth)(nil)
+
+// ListAPIKeys returns API keys in ascending order, the
+// cursor being the last API key of the previous page.
+func (m *SQLAuth) ListAPIKeys(cursor string, limit int) ([]string, string, error) {
+	query := "SELECT api_key from " + m.tableName + " where api_key>? ORDER BY api_key"
+	args := []interface{}{cursor}
+	if limit > 0 {
+		// Fetch one extra row to find out if there is a next page.
+		query += " LIMIT ?"
+		args = append(args, limit+1)
+	}
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, "", err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	if limit <= 0 || len(keys) <= limit {
+		return keys, "", nil
+	}
+	keys = keys[:limit]
+	return keys, keys[limit-1], nil
+}
+
 func (m *SQLAuth) UpsertSecret(apiKey, apiSecret string) error {
 	result, err := m.db.Exec(`
 IF EXISTS (SELECT * from `+m.tableName+`where api_key=?)
@@ -79,6 +149,85 @@ END
 	return nil
 }
 
+var (
+	_ authmid.ScopeBackend = (*SQLAuth)(nil)
+	_ authmid.ScopeWriter  = (*SQLAuth)(nil)
+)
+
+var errNoScopesColumn = errors.New("the table has no scopes column, add it with: ALTER TABLE <table> ADD COLUMN scopes varchar(1024)")
+
+// hasScopesColumn reports whether the table has the scopes column, which
+// tables from before scopes lack. It's checked once, on first use.
+func (m *SQLAuth) hasScopesColumn() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.scopesChecked {
+		return m.scopesColumn, nil
+	}
+	rows, err := m.db.Query("SELECT * from " + m.tableName + " LIMIT 0")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return false, err
+	}
+	for _, column := range columns {
+		if strings.EqualFold(column, "scopes") {
+			m.scopesColumn = true
+		}
+	}
+	m.scopesChecked = true
+	return m.scopesColumn, nil
+}
+
+// SetScopes stores scopes comma separated in the scopes column,
+// failing if the table lacks it.
+func (m *SQLAuth) SetScopes(apiKey string, scopes []string) error {
+	ok, err := m.hasScopesColumn()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNoScopesColumn
+	}
+	result, err := m.db.Exec("UPDATE "+m.tableName+" SET scopes=? WHERE api_key=?", strings.Join(scopes, ","), apiKey)
+	if err != nil {
+		return err
+	}
+	return errOnNoRowsAffect(result)
+}
+
+// LookupScopes returns no scopes if the table lacks the scopes column.
+func (m *SQLAuth) LookupScopes(apiKey string) ([]string, error) {
+	ok, err := m.hasScopesColumn()
+	if err != nil || !ok {
+		return nil, err
+	}
+	rows, err := m.db.Query("SELECT scopes from "+m.tableName+" where api_key=?", apiKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var scopes sql.NullString
+		if err := rows.Scan(&scopes); err != nil {
+			return nil, err
+		}
+		if !scopes.Valid || scopes.String == "" {
+			return nil, nil
+		}
+		return strings.Split(scopes.String, ","), nil
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return nil, authmid.ErrNoSuchAPIKey
+}
+
 func (m *SQLAuth) DeleteAPIKey(apiKey string) error {
 	result, err := m.db.Exec(`DELETE from ? where api_key=?`, m.tableName, apiKey)
 	if err != nil {
@@ -121,6 +270,7 @@ if false {
 	m := &SQLAuth{
 		db:        db,
 		tableName: tableName,
+		dbType:    dbType,
 	}
 	return m, nil
 }
@@ -133,6 +283,7 @@ CREATE TABLE IF NOT EXISTS %s(
  id integer NOT NULL AUTO_INCREMENT,
  key varchar(1024),
  sec varchar(1024),
+ scopes varchar(1024),
  PRIMARY KEY(id)
 )`, tableName)
 	default:
@@ -140,7 +291,8 @@ CREATE TABLE IF NOT EXISTS %s(
 CREATE TABLE IF NOT EXISTS %s(
  id INTEGER AUTOINCREMENT,
  api_key varchar(1024),
- api_secret varchar(1024)
+ api_secret varchar(1024),
+ scopes varchar(1024)
 );`, tableName)
 
 	}
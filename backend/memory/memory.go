@@ -15,13 +15,17 @@
 package memory
 
 import (
-	"errors"
+	"sort"
 	"sync"
+
+	"github.com/orijtech/authmid"
 )
 
 type Memory struct {
-	m  map[string]string
-	mu sync.Mutex
+	m      map[string]string
+	scopes map[string][]string
+	limits map[string]authmid.Limit
+	mu     sync.Mutex
 }
 
 func (m *Memory) Close() error {
@@ -39,6 +43,8 @@ func (m *Memory) UpsertSecret(apiKey, apiSecret string) error {
 func (m *Memory) DeleteAPIKey(apiKey string) error {
 	m.mu.Lock()
 	delete(m.m, apiKey)
+	delete(m.scopes, apiKey)
+	delete(m.limits, apiKey)
 	m.mu.Unlock()
 
 	return nil
@@ -48,7 +54,53 @@ func NewWithMap(m map[string]string) (*Memory, error) {
 	return &Memory{m: m}, nil
 }
 
-var errNoSuchSecret = errors.New("no such secret")
+var _ authmid.Lister = (*Memory)(nil)
+
+// ListAPIKeys returns the API keys in sorted order, the
+// cursor being the last API key of the previous page.
+func (m *Memory) ListAPIKeys(cursor string, limit int) ([]string, string, error) {
+	m.mu.Lock()
+	keys := make([]string, 0, len(m.m))
+	for key := range m.m {
+		if cursor == "" || key > cursor {
+			keys = append(keys, key)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Strings(keys)
+	if limit <= 0 || len(keys) <= limit {
+		return keys, "", nil
+	}
+	keys = keys[:limit]
+	return keys, keys[limit-1], nil
+}
+
+var (
+	_ authmid.ScopeBackend = (*Memory)(nil)
+	_ authmid.ScopeWriter  = (*Memory)(nil)
+)
+
+func (m *Memory) SetScopes(apiKey string, scopes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.m[apiKey]; !ok {
+		return authmid.ErrNoSuchAPIKey
+	}
+	if m.scopes == nil {
+		m.scopes = make(map[string][]string)
+	}
+	m.scopes[apiKey] = append([]string(nil), scopes...)
+	return nil
+}
+
+func (m *Memory) LookupScopes(apiKey string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]string(nil), m.scopes[apiKey]...), nil
+}
 
 func (m *Memory) LookupSecret(apiKey string) ([]byte, error) {
 	m.mu.Lock()
@@ -56,7 +108,7 @@ func (m *Memory) LookupSecret(apiKey string) ([]byte, error) {
 
 	secret, ok := m.m[apiKey]
 	if !ok {
-		return nil, errNoSuchSecret
+		return nil, authmid.ErrNoSuchAPIKey
 	}
 	return []byte(secret), nil
 }
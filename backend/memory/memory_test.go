@@ -0,0 +1,111 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+)
+
+func TestListAPIKeys(t *testing.T) {
+	m, _ := memory.NewWithMap(map[string]string{
+		"delta": "4", "alpha": "1", "echo": "5", "charlie": "3", "bravo": "2",
+	})
+	var lister authmid.Lister = m
+
+	tests := [...]struct {
+		cursor     string
+		limit      int
+		want       []string
+		wantCursor string
+	}{
+		0: {limit: 2, want: []string{"alpha", "bravo"}, wantCursor: "bravo"},
+		1: {cursor: "bravo", limit: 2, want: []string{"charlie", "delta"}, wantCursor: "delta"},
+		2: {cursor: "delta", limit: 2, want: []string{"echo"}},
+		3: {want: []string{"alpha", "bravo", "charlie", "delta", "echo"}},
+		4: {cursor: "echo", limit: 2, want: []string{}},
+	}
+
+	for i, tt := range tests {
+		got, gotCursor, err := lister.ListAPIKeys(tt.cursor, tt.limit)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d: got %q want %q", i, got, tt.want)
+		}
+		if gotCursor != tt.wantCursor {
+			t.Errorf("#%d: gotCursor=%q wantCursor=%q", i, gotCursor, tt.wantCursor)
+		}
+	}
+}
+
+func TestRateLimiterForgetsFullBuckets(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	rl := memory.NewRateLimiter()
+	rl.Now = func() time.Time { return now }
+	limit := authmid.Limit{Rate: 1, Burst: 10}
+
+	for i := 0; i < 100; i++ {
+		if _, _, err := rl.Allow(fmt.Sprintf("key-%d", i), limit); err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+	}
+	if got := rl.Len(); got != 100 {
+		t.Fatalf("got %d buckets want 100", got)
+	}
+
+	// After a minute every bucket has refilled, and only the new one is kept.
+	now = now.Add(time.Minute)
+	if allowed, _, _ := rl.Allow("key-0", limit); !allowed {
+		t.Errorf("a refilled bucket denied a request")
+	}
+	if got := rl.Len(); got != 1 {
+		t.Errorf("got %d buckets want 1", got)
+	}
+}
+
+func TestFailureTrackerForgetsExpiredCounts(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	ft := memory.NewFailureTracker()
+	ft.Now = func() time.Time { return now }
+	policy := authmid.FailurePolicy{MaxFailures: 3, Window: time.Minute, Lockout: 5 * time.Minute}
+
+	for i := 0; i < 100; i++ {
+		ft.Fail(fmt.Sprintf("key:sprayed-%d", i), policy)
+	}
+	for i := 0; i < 3; i++ {
+		ft.Fail("ip:10.0.0.1", policy)
+	}
+	if got := ft.Len(); got != 101 {
+		t.Fatalf("got %d counts want 101", got)
+	}
+
+	// The sprayed keys' windows have passed, the IP is still locked out.
+	now = now.Add(2 * time.Minute)
+	ft.Fail("key:another", policy)
+	if got := ft.Len(); got != 2 {
+		t.Errorf("got %d counts want 2", got)
+	}
+	if d, _ := ft.LockedOut("ip:10.0.0.1"); d != 3*time.Minute {
+		t.Errorf("got lockout %v want 3m", d)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/orijtech/authmid"
+)
+
+// RateLimiter keeps token buckets in process memory, so each
+// process enforces its limits independently of the others.
+// Buckets that have refilled are forgotten, every sweepInterval.
+type RateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+
+	// Now if set replaces time.Now, for tests.
+	Now func() time.Time
+}
+
+var _ authmid.RateLimiter = (*RateLimiter)(nil)
+
+type bucket struct {
+	authmid.TokenBucket
+	limit authmid.Limit
+}
+
+const sweepInterval = time.Minute
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: make(map[string]*bucket)}
+}
+
+func (rl *RateLimiter) Allow(key string, limit authmid.Limit) (bool, time.Duration, error) {
+	now := time.Now
+	if rl.Now != nil {
+		now = rl.Now
+	}
+
+	if err := limit.Validate(); err != nil {
+		return false, 0, err
+	}
+	t := now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if t.Sub(rl.lastSweep) >= sweepInterval {
+		rl.sweep(t)
+	}
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = new(bucket)
+		rl.buckets[key] = b
+	}
+	b.limit = limit
+	allowed, retryAfter := b.Take(limit, t)
+	return allowed, retryAfter, nil
+}
+
+// sweep forgets the buckets that have refilled, as a new bucket would be
+// full too. Otherwise every key ever seen would be kept forever.
+func (rl *RateLimiter) sweep(now time.Time) {
+	for key, b := range rl.buckets {
+		if b.Full(b.limit, now) {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// Len returns the number of buckets held.
+func (rl *RateLimiter) Len() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return len(rl.buckets)
+}
+
+var (
+	_ authmid.LimitBackend = (*Memory)(nil)
+	_ authmid.LimitWriter  = (*Memory)(nil)
+)
+
+// SetLimit overrides the rate limit of apiKey, a nil limit clears it.
+func (m *Memory) SetLimit(apiKey string, limit *authmid.Limit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.m[apiKey]; !ok {
+		return authmid.ErrNoSuchAPIKey
+	}
+	if limit == nil {
+		delete(m.limits, apiKey)
+		return nil
+	}
+	if err := limit.Validate(); err != nil {
+		return err
+	}
+	if m.limits == nil {
+		m.limits = make(map[string]authmid.Limit)
+	}
+	m.limits[apiKey] = *limit
+	return nil
+}
+
+func (m *Memory) LookupLimit(apiKey string) (*authmid.Limit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	limit, ok := m.limits[apiKey]
+	if !ok {
+		return nil, nil
+	}
+	return &limit, nil
+}
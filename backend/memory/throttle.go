@@ -0,0 +1,101 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/orijtech/authmid"
+)
+
+// FailureTracker counts failures in process memory, so each
+// process locks sources out independently of the others.
+// Expired counts are forgotten, every sweepInterval.
+type FailureTracker struct {
+	mu        sync.Mutex
+	counts    map[string]*authmid.FailureCount
+	lastSweep time.Time
+
+	// Now if set replaces time.Now, for tests.
+	Now func() time.Time
+}
+
+var _ authmid.FailureTracker = (*FailureTracker)(nil)
+
+func NewFailureTracker() *FailureTracker {
+	return &FailureTracker{counts: make(map[string]*authmid.FailureCount)}
+}
+
+func (ft *FailureTracker) now() time.Time {
+	if ft.Now != nil {
+		return ft.Now()
+	}
+	return time.Now()
+}
+
+func (ft *FailureTracker) Fail(key string, policy authmid.FailurePolicy) (bool, error) {
+	now := ft.now()
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	if now.Sub(ft.lastSweep) >= sweepInterval {
+		ft.sweep(policy, now)
+	}
+	fc, ok := ft.counts[key]
+	if !ok {
+		fc = new(authmid.FailureCount)
+		ft.counts[key] = fc
+	}
+	return fc.Fail(policy, now), nil
+}
+
+func (ft *FailureTracker) LockedOut(key string) (time.Duration, error) {
+	now := ft.now()
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	fc, ok := ft.counts[key]
+	if !ok {
+		return 0, nil
+	}
+	remaining := fc.LockedOut(now)
+	if remaining == 0 && fc.Failures == 0 {
+		// Neither locked out nor counting, forget key.
+		delete(ft.counts, key)
+	}
+	return remaining, nil
+}
+
+// sweep forgets the expired counts, otherwise a client spraying
+// made up API keys would have every one of them kept forever.
+func (ft *FailureTracker) sweep(policy authmid.FailurePolicy, now time.Time) {
+	for key, fc := range ft.counts {
+		if fc.Expired(policy, now) {
+			delete(ft.counts, key)
+		}
+	}
+	ft.lastSweep = now
+}
+
+// Len returns the number of sources whose failures are held.
+func (ft *FailureTracker) Len() int {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	return len(ft.counts)
+}
@@ -22,6 +22,11 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 )
 
+// New returns a backend over the keys in tableName. Scopes are kept in its
+// scopes column, which tables from before scopes lack: keys then have no
+// scopes until it's added, and the backend reopened, with
+//
+//	ALTER TABLE authmid_keys ADD COLUMN scopes varchar(1024)
 func New(tableName, dbURL string) (authmid.Backend, error) {
 	return sql.New("mysql", tableName, dbURL)
 }
@@ -0,0 +1,164 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+
+	"github.com/orijtech/authmid"
+)
+
+// takeTokenScript is authmid.TokenBucket.Take run atomically in Redis,
+// against the server's clock so that every process agrees on it.
+// It returns whether a token was taken and, if not, the wait in microseconds.
+const takeTokenScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+if tokens == nil then
+  tokens = burst
+elseif now > last then
+  tokens = math.min(burst, tokens + (now - last) / 1000000 * rate)
+end
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+elseif rate > 0 then
+  wait = math.ceil((1 - tokens) / rate * 1000000)
+else
+  wait = -1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "last", now)
+if rate > 0 then
+  redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+end
+return {allowed, wait}
+`
+
+// RateLimiter is an authmid.RateLimiter whose buckets are shared by
+// every process using the same Redis, in keys named prefix:<key>.
+type RateLimiter struct {
+	closeOnce sync.Once
+	pool      *redigo.Pool
+	prefix    string
+}
+
+var _ authmid.RateLimiter = (*RateLimiter)(nil)
+
+func NewRateLimiter(prefix, dbURL string) (*RateLimiter, error) {
+	if strings.TrimSpace(prefix) == "" {
+		return nil, authmid.ErrEmptyTableName
+	}
+	return &RateLimiter{pool: newPool(dbURL), prefix: prefix}, nil
+}
+
+func (rl *RateLimiter) Allow(key string, limit authmid.Limit) (bool, time.Duration, error) {
+	if err := limit.Validate(); err != nil {
+		return false, 0, err
+	}
+	reply, err := do(rl.pool, "EVAL", takeTokenScript, 1, rl.prefix+":"+key, limit.Rate, limit.Burst)
+	if err != nil {
+		return false, 0, err
+	}
+	parts, ok := reply.([]interface{})
+	if !ok || len(parts) != 2 {
+		return false, 0, fmt.Errorf("unexpected EVAL reply %v", reply)
+	}
+	allowed, _ := parts[0].(int64)
+	waitMicros, _ := parts[1].(int64)
+	if allowed == 1 {
+		return true, 0, nil
+	}
+	if waitMicros < 0 {
+		// The bucket never refills.
+		return false, time.Duration(math.MaxInt64), nil
+	}
+	return false, time.Duration(waitMicros) * time.Microsecond, nil
+}
+
+func (rl *RateLimiter) Close() error {
+	var err error = errAlreadyClosed
+	rl.closeOnce.Do(func() {
+		err = rl.pool.Close()
+	})
+	return err
+}
+
+var (
+	_ authmid.LimitBackend = (*redisConnector)(nil)
+	_ authmid.LimitWriter  = (*redisConnector)(nil)
+)
+
+// limitsTableName is the hash table in which limit overrides are
+// stored as "rate/burst", alongside the secrets' hash table.
+func (rc *redisConnector) limitsTableName() string {
+	return rc.hTableName + ":limits"
+}
+
+func (rc *redisConnector) SetLimit(apiKey string, limit *authmid.Limit) error {
+	if limit == nil {
+		_, err := rc.c.HDel(rc.limitsTableName(), apiKey)
+		return err
+	}
+	if err := limit.Validate(); err != nil {
+		return err
+	}
+	if _, err := rc.LookupSecret(apiKey); err != nil {
+		return err
+	}
+	value := strconv.FormatFloat(limit.Rate, 'g', -1, 64) + "/" + strconv.Itoa(limit.Burst)
+	_, err := rc.c.HSet(rc.limitsTableName(), apiKey, value)
+	return err
+}
+
+func (rc *redisConnector) LookupLimit(apiKey string) (*authmid.Limit, error) {
+	value, err := rc.c.HGet(rc.limitsTableName(), apiKey)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	str, err := replyString(value)
+	if err != nil {
+		return nil, err
+	}
+	i := strings.IndexByte(str, '/')
+	if i < 0 {
+		return nil, fmt.Errorf("malformed limit %q", str)
+	}
+	rate, err := strconv.ParseFloat(str[:i], 64)
+	if err != nil {
+		return nil, err
+	}
+	burst, err := strconv.Atoi(str[i+1:])
+	if err != nil {
+		return nil, err
+	}
+	return &authmid.Limit{Rate: rate, Burst: burst}, nil
+}
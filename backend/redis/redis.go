@@ -15,18 +15,30 @@
 package redis
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	redigo "github.com/gomodule/redigo/redis"
 	"github.com/odeke-em/redtable"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/orijtech/authmid"
 )
 
+const tracerName = "github.com/orijtech/authmid/backend/redis"
+
 type redisConnector struct {
 	closeOnce  sync.Once
 	c          *redtable.Client
+	pool       *redigo.Pool
 	hTableName string
 }
 
@@ -38,18 +50,55 @@ func New(hashTableName, dbURL string) (authmid.Backend, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &redisConnector{c: c, hTableName: hashTableName}, nil
+	return &redisConnector{c: c, pool: newPool(dbURL), hTableName: hashTableName}, nil
+}
+
+// newPool connects to dbURL, e.g. "redis://localhost:6379", for the
+// commands that redtable doesn't wrap such as HSCAN and EVAL.
+func newPool(dbURL string) *redigo.Pool {
+	return &redigo.Pool{
+		MaxIdle:     4,
+		IdleTimeout: 5 * time.Minute,
+		Dial: func() (redigo.Conn, error) {
+			return redigo.DialURL(dbURL)
+		},
+	}
+}
+
+func do(pool *redigo.Pool, cmd string, args ...interface{}) (interface{}, error) {
+	conn := pool.Get()
+	defer conn.Close()
+	return conn.Do(cmd, args...)
 }
 
 var _ authmid.Backend = (*redisConnector)(nil)
 
+var _ authmid.ContextBackend = (*redisConnector)(nil)
+
 func (rc *redisConnector) LookupSecret(apiKey string) ([]byte, error) {
+	return rc.LookupSecretContext(context.Background(), apiKey)
+}
+
+// LookupSecretContext records the lookup as a child span of ctx's,
+// redtable itself doesn't take contexts.
+func (rc *redisConnector) LookupSecretContext(ctx context.Context, apiKey string) (secret []byte, err error) {
+	_, span := otel.Tracer(tracerName).Start(ctx, "redis.HGET", trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(
+		attribute.String("db.system", "redis"),
+		attribute.String(authmid.AttrAPIKey, apiKey),
+	)
+	defer func() {
+		if err != nil && err != authmid.ErrNoSuchAPIKey {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	value, err := rc.c.HGet(rc.hTableName, apiKey)
 	if err != nil {
 		return nil, err
 	}
 
-	var secret []byte
 	switch typedV := value.(type) {
 	case []byte:
 		secret = typedV
@@ -72,9 +121,102 @@ func (rc *redisConnector) DeleteAPIKey(apiKey string) error {
 	if err != nil {
 		return err
 	}
+	// The key might never have had scopes or a limit.
+	_, _ = rc.c.HDel(rc.scopesTableName(), apiKey)
+	_, _ = rc.c.HDel(rc.limitsTableName(), apiKey)
 	return errOnNoRowsAffected(n)
 }
 
+var (
+	_ authmid.ScopeBackend = (*redisConnector)(nil)
+	_ authmid.ScopeWriter  = (*redisConnector)(nil)
+)
+
+// scopesTableName is the hash table in which scopes are stored, comma
+// separated, alongside the secrets' hash table.
+func (rc *redisConnector) scopesTableName() string {
+	return rc.hTableName + ":scopes"
+}
+
+func (rc *redisConnector) SetScopes(apiKey string, scopes []string) error {
+	if _, err := rc.LookupSecret(apiKey); err != nil {
+		return err
+	}
+	_, err := rc.c.HSet(rc.scopesTableName(), apiKey, strings.Join(scopes, ","))
+	return err
+}
+
+func (rc *redisConnector) LookupScopes(apiKey string) ([]string, error) {
+	value, err := rc.c.HGet(rc.scopesTableName(), apiKey)
+	if err != nil {
+		return nil, err
+	}
+	var joined string
+	switch typedV := value.(type) {
+	case []byte:
+		joined = string(typedV)
+	case string:
+		joined = typedV
+	}
+	if joined == "" {
+		return nil, nil
+	}
+	return strings.Split(joined, ","), nil
+}
+
+var _ authmid.Lister = (*redisConnector)(nil)
+
+// ListAPIKeys pages through the hash table with HSCAN, whose cursor is
+// opaque. As with HSCAN, limit is only a hint and keys are unordered.
+func (rc *redisConnector) ListAPIKeys(cursor string, limit int) ([]string, string, error) {
+	if cursor == "" {
+		cursor = "0"
+	}
+	args := []interface{}{rc.hTableName, cursor}
+	if limit > 0 {
+		args = append(args, "COUNT", limit)
+	}
+	reply, err := do(rc.pool, "HSCAN", args...)
+	if err != nil {
+		return nil, "", err
+	}
+	parts, ok := reply.([]interface{})
+	if !ok || len(parts) != 2 {
+		return nil, "", fmt.Errorf("unexpected HSCAN reply: %#v", reply)
+	}
+	nextCursor, err := replyString(parts[0])
+	if err != nil {
+		return nil, "", err
+	}
+	if nextCursor == "0" {
+		// HSCAN signals completion by returning a zero cursor.
+		nextCursor = ""
+	}
+	fieldsAndValues, _ := parts[1].([]interface{})
+	var keys []string
+	for i := 0; i < len(fieldsAndValues); i += 2 {
+		key, err := replyString(fieldsAndValues[i])
+		if err != nil {
+			return nil, "", err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nextCursor, nil
+}
+
+func replyString(v interface{}) (string, error) {
+	switch typedV := v.(type) {
+	case []byte:
+		return string(typedV), nil
+	case string:
+		return typedV, nil
+	case int64:
+		return strconv.FormatInt(typedV, 10), nil
+	default:
+		return "", fmt.Errorf("unexpected reply type %T", v)
+	}
+}
+
 var errNoEntriesMatched = errors.New("no entries matched")
 
 func errOnNoRowsAffected(n interface{}) error {
@@ -103,6 +245,9 @@ func (rc *redisConnector) Close() error {
 	var err error = errAlreadyClosed
 	rc.closeOnce.Do(func() {
 		err = rc.c.Close()
+		if perr := rc.pool.Close(); err == nil {
+			err = perr
+		}
 	})
 	return err
 }
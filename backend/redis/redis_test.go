@@ -0,0 +1,125 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis_test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/redis"
+)
+
+func TestListAPIKeys(t *testing.T) {
+	mr := miniredis.RunT(t)
+	var want []string
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		mr.HSet("keys", key, "secret")
+		want = append(want, key)
+	}
+	backend, err := redis.New("keys", "redis://"+mr.Addr())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer backend.Close()
+
+	lister := backend.(authmid.Lister)
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatalf("the cursor never completes, last %q", cursor)
+		}
+		keys, next, err := lister.ListAPIKeys(cursor, 10)
+		if err != nil {
+			t.Fatalf("ListAPIKeys(%q): %v", cursor, err)
+		}
+		got = append(got, keys...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	sort.Strings(got)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rl, err := redis.NewRateLimiter("limits", "redis://"+mr.Addr())
+	if err != nil {
+		t.Fatalf("NewRateLimiter: %v", err)
+	}
+	defer rl.Close()
+
+	limit := authmid.Limit{Rate: 1, Burst: 2}
+	for i, wantAllowed := range []bool{true, true, false} {
+		allowed, wait, err := rl.Allow("partner", limit)
+		if err != nil {
+			t.Fatalf("#%d: Allow: %v", i, err)
+		}
+		if allowed != wantAllowed {
+			t.Errorf("#%d: got allowed=%v want %v", i, allowed, wantAllowed)
+		}
+		if !allowed && (wait <= 0 || wait > time.Second) {
+			t.Errorf("#%d: got wait %v, expecting at most a second", i, wait)
+		}
+	}
+	// Buckets are per key.
+	if allowed, _, err := rl.Allow("other", limit); err != nil || !allowed {
+		t.Errorf("got (%v, %v) for another key", allowed, err)
+	}
+	if ttl := mr.TTL("limits:partner"); ttl <= 0 {
+		t.Errorf("got TTL %v, expecting buckets to expire", ttl)
+	}
+}
+
+func TestFailureTracker(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ft, err := redis.NewFailureTracker("throttle", "redis://"+mr.Addr())
+	if err != nil {
+		t.Fatalf("NewFailureTracker: %v", err)
+	}
+	defer ft.Close()
+
+	policy := authmid.FailurePolicy{MaxFailures: 3, Window: time.Minute, Lockout: 10 * time.Minute}
+	for i, wantLocked := range []bool{false, false, true} {
+		locked, err := ft.Fail("ip:10.0.0.1", policy)
+		if err != nil {
+			t.Fatalf("#%d: Fail: %v", i, err)
+		}
+		if locked != wantLocked {
+			t.Errorf("#%d: got locked=%v want %v", i, locked, wantLocked)
+		}
+	}
+	if d, err := ft.LockedOut("ip:10.0.0.1"); err != nil || d <= 9*time.Minute || d > 10*time.Minute {
+		t.Errorf("got (%v, %v), expecting a lockout of about 10m", d, err)
+	}
+	if d, err := ft.LockedOut("ip:10.0.0.2"); err != nil || d != 0 {
+		t.Errorf("got (%v, %v) for a source without failures", d, err)
+	}
+
+	mr.FastForward(11 * time.Minute)
+	if d, err := ft.LockedOut("ip:10.0.0.1"); err != nil || d != 0 {
+		t.Errorf("got (%v, %v) after the lockout expired", d, err)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+
+	"github.com/orijtech/authmid"
+)
+
+// failScript counts a failure in a window that starts with the first
+// failure and expires on its own, locking the source out at the limit.
+const failScript = `
+local failures = redis.call("INCR", KEYS[1])
+if failures == 1 then
+  redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if tonumber(ARGV[1]) > 0 and failures >= tonumber(ARGV[1]) then
+  redis.call("DEL", KEYS[1])
+  redis.call("SET", KEYS[2], 1, "PX", ARGV[3])
+  return 1
+end
+return 0
+`
+
+// FailureTracker is an authmid.FailureTracker shared by every process using
+// the same Redis, in keys named prefix:failures:<key> and prefix:lockout:<key>.
+type FailureTracker struct {
+	closeOnce sync.Once
+	pool      *redigo.Pool
+	prefix    string
+}
+
+var _ authmid.FailureTracker = (*FailureTracker)(nil)
+
+func NewFailureTracker(prefix, dbURL string) (*FailureTracker, error) {
+	if strings.TrimSpace(prefix) == "" {
+		return nil, authmid.ErrEmptyTableName
+	}
+	return &FailureTracker{pool: newPool(dbURL), prefix: prefix}, nil
+}
+
+func (ft *FailureTracker) Fail(key string, policy authmid.FailurePolicy) (bool, error) {
+	reply, err := do(ft.pool, "EVAL", failScript, 2,
+		ft.prefix+":failures:"+key, ft.prefix+":lockout:"+key,
+		policy.MaxFailures, millis(policy.Window), millis(policy.Lockout))
+	if err != nil {
+		return false, err
+	}
+	locked, _ := reply.(int64)
+	return locked == 1, nil
+}
+
+func (ft *FailureTracker) LockedOut(key string) (time.Duration, error) {
+	reply, err := do(ft.pool, "PTTL", ft.prefix+":lockout:"+key)
+	if err != nil {
+		return 0, err
+	}
+	// PTTL is negative for keys that don't exist or never expire.
+	if ms, _ := reply.(int64); ms > 0 {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	return 0, nil
+}
+
+func (ft *FailureTracker) Close() error {
+	var err error = errAlreadyClosed
+	ft.closeOnce.Do(func() {
+		err = ft.pool.Close()
+	})
+	return err
+}
+
+// millis rounds d up to whole milliseconds, at least one as
+// Redis rejects expiries that aren't positive.
+func millis(d time.Duration) int64 {
+	ms := int64((d + time.Millisecond - 1) / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	return ms
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3_test
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/sqlite3"
+)
+
+// newDB returns the URL of a database with the keys in table "keys".
+func newDB(t *testing.T, keys map[string]string) string {
+	dbURL := filepath.Join(t.TempDir(), "keys.db")
+	db, err := sql.Open("sqlite3", dbURL)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE keys(api_key varchar(1024) PRIMARY KEY, secret varchar(1024))"); err != nil {
+		t.Fatalf("CREATE TABLE: %v", err)
+	}
+	for key, secret := range keys {
+		if _, err := db.Exec("INSERT INTO keys(api_key, secret) VALUES(?, ?)", key, secret); err != nil {
+			t.Fatalf("INSERT: %v", err)
+		}
+	}
+	return dbURL
+}
+
+func TestListAPIKeys(t *testing.T) {
+	backend, err := sqlite3.New("keys", newDB(t, map[string]string{
+		"delta": "4", "alpha": "1", "echo": "5", "charlie": "3", "bravo": "2",
+	}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer backend.Close()
+	lister := backend.(authmid.Lister)
+
+	tests := [...]struct {
+		cursor     string
+		limit      int
+		want       []string
+		wantCursor string
+	}{
+		0: {limit: 2, want: []string{"alpha", "bravo"}, wantCursor: "bravo"},
+		1: {cursor: "bravo", limit: 2, want: []string{"charlie", "delta"}, wantCursor: "delta"},
+		2: {cursor: "delta", limit: 2, want: []string{"echo"}},
+		3: {want: []string{"alpha", "bravo", "charlie", "delta", "echo"}},
+		4: {cursor: "echo", limit: 2, want: []string{}},
+		// A page that ends exactly at the last key has no next page.
+		5: {cursor: "charlie", limit: 2, want: []string{"delta", "echo"}},
+	}
+
+	for i, tt := range tests {
+		got, gotCursor, err := lister.ListAPIKeys(tt.cursor, tt.limit)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d: got %q want %q", i, got, tt.want)
+		}
+		if gotCursor != tt.wantCursor {
+			t.Errorf("#%d: gotCursor=%q wantCursor=%q", i, gotCursor, tt.wantCursor)
+		}
+	}
+}
+
+func TestScopesColumn(t *testing.T) {
+	dbURL := newDB(t, map[string]string{"k": "s"})
+	backend, err := sqlite3.New("keys", dbURL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// Tables from before scopes authenticate keys without any.
+	ha := &authmid.HeaderAuthenticator{Backend: backend, KeyHeader: "X-Key", SignatureHeader: "X-Signature"}
+	var principal *authmid.Principal
+	handler := authmid.Middleware(ha, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = authmid.PrincipalFromContext(r.Context())
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	if err := ha.SignRequest(req, "k", []byte("s")); err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || principal == nil || len(principal.Scopes) != 0 {
+		t.Fatalf("got %d %q, principal %+v", rec.Code, rec.Body, principal)
+	}
+	sw := backend.(authmid.ScopeWriter)
+	if err := sw.SetScopes("k", []string{"orders:read"}); err == nil {
+		t.Errorf("SetScopes: expecting an error without the scopes column")
+	}
+	backend.Close()
+
+	db, err := sql.Open("sqlite3", dbURL)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := db.Exec("ALTER TABLE keys ADD COLUMN scopes varchar(1024)"); err != nil {
+		t.Fatalf("ALTER TABLE: %v", err)
+	}
+	db.Close()
+
+	backend, err = sqlite3.New("keys", dbURL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer backend.Close()
+	if scopes, err := backend.(authmid.ScopeBackend).LookupScopes("k"); err != nil || len(scopes) != 0 {
+		t.Errorf("before SetScopes: got (%v, %v)", scopes, err)
+	}
+	if err := backend.(authmid.ScopeWriter).SetScopes("k", []string{"orders:read", "orders:write"}); err != nil {
+		t.Fatalf("SetScopes: %v", err)
+	}
+	scopes, err := backend.(authmid.ScopeBackend).LookupScopes("k")
+	if err != nil || !reflect.DeepEqual(scopes, []string{"orders:read", "orders:write"}) {
+		t.Errorf("got (%v, %v)", scopes, err)
+	}
+	if _, err := backend.(authmid.ScopeBackend).LookupScopes("absent"); err != authmid.ErrNoSuchAPIKey {
+		t.Errorf("absent key: got %v want %v", err, authmid.ErrNoSuchAPIKey)
+	}
+}
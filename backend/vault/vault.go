@@ -0,0 +1,377 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vault implements authmid.Backend on top of the
+// HashiCorp Vault KV version 2 secrets engine HTTP API.
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orijtech/authmid"
+)
+
+type Config struct {
+	// Address is the base URL of the Vault server e.g. "https://vault:8200".
+	Address string
+
+	Token string
+
+	// Mount is the path where the KV v2 engine is mounted,
+	// it defaults to "secret".
+	Mount string
+
+	// Prefix is prepended to every API key to form the
+	// secret's path within the mount e.g. "authmid/keys".
+	Prefix string
+
+	// Namespace is sent as X-Vault-Namespace if set.
+	Namespace string
+
+	// RenewInterval if non-zero periodically renews Token
+	// until Close is invoked.
+	RenewInterval time.Duration
+
+	HTTPClient *http.Client
+}
+
+type Vault struct {
+	closeOnce sync.Once
+	stopRenew chan struct{}
+
+	baseURL   string
+	mount     string
+	prefix    string
+	namespace string
+	client    *http.Client
+
+	mu    sync.RWMutex
+	token string
+}
+
+var _ authmid.Backend = (*Vault)(nil)
+
+var (
+	errNilConfig      = errors.New("expecting a non-nil config")
+	errEmptyAddress   = errors.New("expecting a non-empty Vault address")
+	errEmptyToken     = errors.New("expecting a non-empty Vault token")
+	errAlreadyClosed  = errors.New("already closed")
+	errInvalidVersion = errors.New("expecting a version >= 1")
+)
+
+const (
+	defaultMount = "secret"
+
+	// secretField is the field within a KV entry's data that holds the API secret.
+	secretField = "secret"
+)
+
+func New(cfg *Config) (*Vault, error) {
+	if cfg == nil {
+		return nil, errNilConfig
+	}
+	if strings.TrimSpace(cfg.Address) == "" {
+		return nil, errEmptyAddress
+	}
+	if strings.TrimSpace(cfg.Token) == "" {
+		return nil, errEmptyToken
+	}
+	if _, err := url.Parse(cfg.Address); err != nil {
+		return nil, err
+	}
+	mount := strings.Trim(cfg.Mount, "/")
+	if mount == "" {
+		mount = defaultMount
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	v := &Vault{
+		baseURL:   strings.TrimSuffix(cfg.Address, "/"),
+		mount:     mount,
+		prefix:    strings.Trim(cfg.Prefix, "/"),
+		namespace: cfg.Namespace,
+		client:    client,
+		token:     cfg.Token,
+		stopRenew: make(chan struct{}),
+	}
+	if cfg.RenewInterval > 0 {
+		go v.renewPeriodically(cfg.RenewInterval)
+	}
+	return v, nil
+}
+
+func (v *Vault) LookupSecret(apiKey string) ([]byte, error) {
+	return v.lookupSecret(apiKey, 0)
+}
+
+// LookupSecretVersion retrieves the secret as it was at the given version.
+func (v *Vault) LookupSecretVersion(apiKey string, version int) ([]byte, error) {
+	if version < 1 {
+		return nil, errInvalidVersion
+	}
+	return v.lookupSecret(apiKey, version)
+}
+
+type kvData struct {
+	Data     map[string]interface{} `json:"data"`
+	Metadata *VersionMetadata       `json:"metadata"`
+}
+
+type VersionMetadata struct {
+	Version      int    `json:"version"`
+	CreatedTime  string `json:"created_time"`
+	DeletionTime string `json:"deletion_time"`
+	Destroyed    bool   `json:"destroyed"`
+}
+
+func (v *Vault) lookupSecret(apiKey string, version int) ([]byte, error) {
+	query := make(url.Values)
+	if version > 0 {
+		query.Set("version", strconv.Itoa(version))
+	}
+	kv := new(kvData)
+	if err := v.do("GET", v.path("data", apiKey), query, nil, kv); err != nil {
+		return nil, err
+	}
+	value, ok := kv.Data[secretField].(string)
+	if !ok {
+		// Soft deleted versions are returned with a nil data field.
+		return nil, authmid.ErrNoSuchAPIKey
+	}
+	return []byte(value), nil
+}
+
+func (v *Vault) UpsertSecret(apiKey, apiSecret string) error {
+	_, err := v.WriteSecret(apiKey, apiSecret)
+	return err
+}
+
+// WriteSecret stores apiSecret as a new version of apiKey
+// and returns the version number that Vault assigned it.
+func (v *Vault) WriteSecret(apiKey, apiSecret string) (int, error) {
+	body := map[string]interface{}{
+		"data": map[string]string{secretField: apiSecret},
+	}
+	meta := new(VersionMetadata)
+	if err := v.do("POST", v.path("data", apiKey), nil, body, meta); err != nil {
+		return 0, err
+	}
+	return meta.Version, nil
+}
+
+// DeleteAPIKey permanently removes apiKey and all of its versions.
+func (v *Vault) DeleteAPIKey(apiKey string) error {
+	if _, err := v.Metadata(apiKey); err != nil {
+		return err
+	}
+	return v.do("DELETE", v.path("metadata", apiKey), nil, nil, nil)
+}
+
+// DeleteVersions soft deletes the given versions of apiKey, they
+// can be recovered with UndeleteVersions.
+func (v *Vault) DeleteVersions(apiKey string, versions ...int) error {
+	return v.do("POST", v.path("delete", apiKey), nil, map[string][]int{"versions": versions}, nil)
+}
+
+func (v *Vault) UndeleteVersions(apiKey string, versions ...int) error {
+	return v.do("POST", v.path("undelete", apiKey), nil, map[string][]int{"versions": versions}, nil)
+}
+
+// DestroyVersions irrecoverably removes the given versions of apiKey.
+func (v *Vault) DestroyVersions(apiKey string, versions ...int) error {
+	return v.do("POST", v.path("destroy", apiKey), nil, map[string][]int{"versions": versions}, nil)
+}
+
+type KeyMetadata struct {
+	CurrentVersion int                        `json:"current_version"`
+	OldestVersion  int                        `json:"oldest_version"`
+	CreatedTime    string                     `json:"created_time"`
+	UpdatedTime    string                     `json:"updated_time"`
+	Versions       map[string]VersionMetadata `json:"versions"`
+}
+
+func (v *Vault) Metadata(apiKey string) (*KeyMetadata, error) {
+	meta := new(KeyMetadata)
+	if err := v.do("GET", v.path("metadata", apiKey), nil, nil, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+type tokenAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int64  `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+// RenewToken renews the token in use and returns its new time to live.
+func (v *Vault) RenewToken() (time.Duration, error) {
+	auth := new(tokenAuth)
+	if err := v.do("POST", "auth/token/renew-self", nil, struct{}{}, auth); err != nil {
+		return 0, err
+	}
+	if auth.ClientToken != "" {
+		v.SetToken(auth.ClientToken)
+	}
+	return time.Duration(auth.LeaseDuration) * time.Second, nil
+}
+
+func (v *Vault) SetToken(token string) {
+	v.mu.Lock()
+	v.token = token
+	v.mu.Unlock()
+}
+
+func (v *Vault) currentToken() string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return v.token
+}
+
+func (v *Vault) renewPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-v.stopRenew:
+			return
+		case <-ticker.C:
+			// Failures are retried on the next tick, and are
+			// otherwise surfaced by the subsequent requests.
+			_, _ = v.RenewToken()
+		}
+	}
+}
+
+func (v *Vault) Close() error {
+	var err error = errAlreadyClosed
+	v.closeOnce.Do(func() {
+		close(v.stopRenew)
+		err = nil
+	})
+	return err
+}
+
+func (v *Vault) path(op, apiKey string) string {
+	segments := []string{v.mount, op}
+	if v.prefix != "" {
+		segments = append(segments, v.prefix)
+	}
+	segments = append(segments, url.PathEscape(apiKey))
+	return strings.Join(segments, "/")
+}
+
+type envelope struct {
+	Data   json.RawMessage `json:"data"`
+	Auth   json.RawMessage `json:"auth"`
+	Errors []string        `json:"errors"`
+}
+
+// Error is a failure status from Vault. It reaches callers wrapped in an
+// *authmid.InternalError, so that Middleware answers it with a bare 500.
+type Error struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *Error) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("vault: %d %s", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+// do calls Vault, failing with authmid.ErrNoSuchAPIKey for 404s and an
+// *authmid.InternalError otherwise, since clients have no use for the details.
+func (v *Vault) do(method, path string, query url.Values, in, out interface{}) error {
+	err := v.roundTrip(method, path, query, in, out)
+	if err == nil || err == authmid.ErrNoSuchAPIKey {
+		return err
+	}
+	return &authmid.InternalError{Err: err}
+}
+
+func (v *Vault) roundTrip(method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		blob, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(blob)
+	}
+	fullURL := v.baseURL + "/v1/" + path
+	if len(query) > 0 {
+		fullURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, fullURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.currentToken())
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	blob, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	env := new(envelope)
+	if len(blob) > 0 {
+		if err := json.Unmarshal(blob, env); err != nil && res.StatusCode/100 == 2 {
+			return err
+		}
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return authmid.ErrNoSuchAPIKey
+	}
+	if res.StatusCode/100 != 2 {
+		return &Error{StatusCode: res.StatusCode, Errors: env.Errors}
+	}
+	if out == nil {
+		return nil
+	}
+	payload := env.Data
+	if len(env.Auth) > 0 && string(env.Auth) != "null" {
+		payload = env.Auth
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+	return json.Unmarshal(payload, out)
+}
@@ -0,0 +1,278 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/vault"
+)
+
+func TestVaultRoundTrip(t *testing.T) {
+	fv := newFakeVault("root-token")
+	srv := httptest.NewServer(fv)
+	defer srv.Close()
+
+	v, err := vault.New(&vault.Config{Address: srv.URL, Token: "root-token", Prefix: "authmid"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer v.Close()
+
+	if _, err := v.LookupSecret("k1"); err != authmid.ErrNoSuchAPIKey {
+		t.Fatalf("lookup of absent key: got err=%v want %v", err, authmid.ErrNoSuchAPIKey)
+	}
+	if err := v.UpsertSecret("k1", "s1"); err != nil {
+		t.Fatalf("UpsertSecret: %v", err)
+	}
+	version, err := v.WriteSecret("k1", "s2")
+	if err != nil {
+		t.Fatalf("WriteSecret: %v", err)
+	}
+	if version != 2 {
+		t.Errorf("version: got %d want 2", version)
+	}
+
+	got, err := v.LookupSecret("k1")
+	if err != nil || string(got) != "s2" {
+		t.Errorf("latest: got (%q, %v) want (%q, nil)", got, err, "s2")
+	}
+	got, err = v.LookupSecretVersion("k1", 1)
+	if err != nil || string(got) != "s1" {
+		t.Errorf("version 1: got (%q, %v) want (%q, nil)", got, err, "s1")
+	}
+
+	if err := v.DeleteVersions("k1", 2); err != nil {
+		t.Fatalf("DeleteVersions: %v", err)
+	}
+	if _, err := v.LookupSecret("k1"); err != authmid.ErrNoSuchAPIKey {
+		t.Errorf("soft deleted: got err=%v want %v", err, authmid.ErrNoSuchAPIKey)
+	}
+	if err := v.UndeleteVersions("k1", 2); err != nil {
+		t.Fatalf("UndeleteVersions: %v", err)
+	}
+	if got, _ := v.LookupSecret("k1"); string(got) != "s2" {
+		t.Errorf("undeleted: got %q want %q", got, "s2")
+	}
+
+	meta, err := v.Metadata("k1")
+	if err != nil {
+		t.Fatalf("Metadata: %v", err)
+	}
+	if meta.CurrentVersion != 2 {
+		t.Errorf("CurrentVersion: got %d want 2", meta.CurrentVersion)
+	}
+
+	if err := v.DeleteAPIKey("k1"); err != nil {
+		t.Fatalf("DeleteAPIKey: %v", err)
+	}
+	if _, err := v.LookupSecret("k1"); err != authmid.ErrNoSuchAPIKey {
+		t.Errorf("deleted key: got err=%v want %v", err, authmid.ErrNoSuchAPIKey)
+	}
+	if err := v.DeleteAPIKey("k1"); err != authmid.ErrNoSuchAPIKey {
+		t.Errorf("deleting twice: got err=%v want %v", err, authmid.ErrNoSuchAPIKey)
+	}
+}
+
+func TestVaultTokenAuthAndRenewal(t *testing.T) {
+	fv := newFakeVault("root-token")
+	srv := httptest.NewServer(fv)
+	defer srv.Close()
+
+	v, err := vault.New(&vault.Config{Address: srv.URL, Token: "bad-token"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer v.Close()
+
+	err = v.UpsertSecret("k1", "s1")
+	var ve *vault.Error
+	if !errors.As(err, &ve) || ve.StatusCode != http.StatusForbidden {
+		t.Fatalf("bad token: got err=%v want a 403 *vault.Error", err)
+	}
+
+	// Clients are told nothing of Vault's failures.
+	ha := &authmid.HeaderAuthenticator{Backend: v, KeyHeader: "X-Key", SignatureHeader: "X-Signature"}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Key", "k1")
+	req.Header.Set("X-Signature", "bogus")
+	rec := httptest.NewRecorder()
+	authmid.Middleware(ha, http.NotFoundHandler()).ServeHTTP(rec, req)
+	if body := rec.Body.String(); rec.Code != http.StatusInternalServerError || strings.Contains(body, "vault") {
+		t.Errorf("middleware: got %d %q want a bare 500", rec.Code, body)
+	}
+
+	v.SetToken("root-token")
+	ttl, err := v.RenewToken()
+	if err != nil {
+		t.Fatalf("RenewToken: %v", err)
+	}
+	if ttl.Seconds() != 3600 {
+		t.Errorf("ttl: got %v want 1h", ttl)
+	}
+	if fv.renewals != 1 {
+		t.Errorf("renewals: got %d want 1", fv.renewals)
+	}
+	if err := v.UpsertSecret("k1", "s1"); err != nil {
+		t.Errorf("after renewal: %v", err)
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	tests := [...]*vault.Config{
+		0: nil,
+		1: {Token: "t"},
+		2: {Address: "http://localhost:8200"},
+	}
+	for i, cfg := range tests {
+		if _, err := vault.New(cfg); err == nil {
+			t.Errorf("#%d: expected an error", i)
+		}
+	}
+}
+
+// fakeVault is a minimal stand-in for the KV v2 and token renewal endpoints.
+type fakeVault struct {
+	mu       sync.Mutex
+	token    string
+	renewals int
+	// versions maps a path to its versions in order, where
+	// a nil entry marks a soft deleted version.
+	versions map[string][]*string
+	deleted  map[string]map[int]string
+}
+
+func newFakeVault(token string) *fakeVault {
+	return &fakeVault{
+		token:    token,
+		versions: make(map[string][]*string),
+		deleted:  make(map[string]map[int]string),
+	}
+}
+
+func (fv *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+
+	if r.Header.Get("X-Vault-Token") != fv.token {
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+	if r.URL.Path == "/v1/auth/token/renew-self" {
+		fv.renewals++
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": fv.token, "lease_duration": 3600, "renewable": true},
+		})
+		return
+	}
+
+	// Paths are of the form /v1/secret/<op>/<path...>
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v1/secret/"), "/", 2)
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	op, path := parts[0], parts[1]
+	switch {
+	case op == "data" && r.Method == "GET":
+		versions := fv.versions[path]
+		n := len(versions)
+		if s := r.URL.Query().Get("version"); s != "" {
+			n, _ = strconv.Atoi(s)
+		}
+		if n == 0 || n > len(versions) || versions[n-1] == nil {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]string{"secret": *versions[n-1]},
+				"metadata": map[string]interface{}{"version": n},
+			},
+		})
+	case op == "data" && (r.Method == "POST" || r.Method == "PUT"):
+		var body struct {
+			Data map[string]string `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{err.Error()}})
+			return
+		}
+		secret := body.Data["secret"]
+		fv.versions[path] = append(fv.versions[path], &secret)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"version": len(fv.versions[path])},
+		})
+	case op == "delete" || op == "undelete" || op == "destroy":
+		var body struct {
+			Versions []int `json:"versions"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		versions := fv.versions[path]
+		if fv.deleted[path] == nil {
+			fv.deleted[path] = make(map[int]string)
+		}
+		for _, n := range body.Versions {
+			if n < 1 || n > len(versions) {
+				continue
+			}
+			switch op {
+			case "delete":
+				if versions[n-1] != nil {
+					fv.deleted[path][n] = *versions[n-1]
+					versions[n-1] = nil
+				}
+			case "undelete":
+				if s, ok := fv.deleted[path][n]; ok {
+					versions[n-1] = &s
+					delete(fv.deleted[path], n)
+				}
+			case "destroy":
+				versions[n-1] = nil
+				delete(fv.deleted[path], n)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case op == "metadata" && r.Method == "GET":
+		versions, ok := fv.versions[path]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"current_version": len(versions), "oldest_version": 1},
+		})
+	case op == "metadata" && r.Method == "DELETE":
+		delete(fv.versions, path)
+		delete(fv.deleted, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,130 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid
+
+import (
+	"net/http"
+	"strings"
+)
+
+type PathPolicy int
+
+const (
+	// DecodedPath signs URL.Path, in which percent-encodings are decoded.
+	DecodedPath PathPolicy = iota
+
+	// EscapedPath signs URL.EscapedPath(), which preserves the
+	// percent-encodings that the client sent e.g. "/files/a%2Fb".
+	EscapedPath
+)
+
+type QueryPolicy int
+
+const (
+	// SortedQuery signs the query parameters sorted by key and re-encoded.
+	SortedQuery QueryPolicy = iota
+
+	// RawQuery signs the query exactly as the client sent it.
+	RawQuery
+)
+
+// Canonicalization selects how a request's method, path, query and
+// headers are rendered into the signed input. The zero value signs the
+// method, the decoded path and the sorted query.
+type Canonicalization struct {
+	ExcludeMethodAndPath bool
+
+	Path  PathPolicy
+	Query QueryPolicy
+
+	// Headers if set are signed after the values from HeaderValues.
+	Headers *SignedHeaders
+}
+
+// Canonicalizer is optionally implemented by an Authenticator to choose
+// how requests are canonicalized, which must match what its clients sign.
+type Canonicalizer interface {
+	Canonicalization() Canonicalization
+}
+
+// CanonicalizationOf returns the Canonicalization that Checker
+// applies for vf, taking ExcludeMethodAndPather into account.
+func CanonicalizationOf(vf interface{}) Canonicalization {
+	var c Canonicalization
+	if cz, ok := vf.(Canonicalizer); ok {
+		c = cz.Canonicalization()
+	}
+	if ex, ok := vf.(ExcludeMethodAndPather); ok && ex.ExcludeMethodAndPath() {
+		c.ExcludeMethodAndPath = true
+	}
+	return c
+}
+
+// canonicalRequest holds the parts of a request that are signed.
+type canonicalRequest struct {
+	headerValues  []string
+	signedHeaders string
+
+	includeMethodAndPath bool
+	method               string
+	path                 string
+
+	body []byte
+}
+
+func canonicalize(req *http.Request, body []byte, headerValues []string, c Canonicalization) *canonicalRequest {
+	cr := &canonicalRequest{
+		headerValues:  headerValues,
+		signedHeaders: c.Headers.Canonical(req, body),
+		body:          body,
+	}
+	if !c.ExcludeMethodAndPath {
+		cr.includeMethodAndPath = true
+		cr.method, cr.path = req.Method, canonicalPath(req, c)
+	}
+	return cr
+}
+
+func canonicalPath(req *http.Request, c Canonicalization) string {
+	urlPath := req.URL.Path
+	if c.Path == EscapedPath {
+		urlPath = req.URL.EscapedPath()
+	}
+	if urlPath == "" {
+		// Clients may omit the path, which servers always receive as "/".
+		urlPath = "/"
+	}
+	switch c.Query {
+	case RawQuery:
+		if req.URL.RawQuery != "" {
+			urlPath += "?" + req.URL.RawQuery
+		}
+	default:
+		if q := req.URL.Query(); len(q) > 0 {
+			urlPath += "?" + q.Encode()
+		}
+	}
+	return urlPath
+}
+
+func (cr *canonicalRequest) signatureInput() string {
+	sigInput := append([]string(nil), cr.headerValues...)
+	sigInput = append(sigInput, cr.signedHeaders)
+	if cr.includeMethodAndPath {
+		sigInput = append(sigInput, cr.method, cr.path)
+	}
+	sigInput = append(sigInput, string(cr.body))
+	return strings.Join(sigInput, "")
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command authmid-proxy verifies signed requests and forwards those that
+// pass to an upstream service, which can trust the X-Authenticated-Key
+// header that identifies the API key. For example:
+//
+//	authmid-proxy -backend redis://localhost:6379 -upstream http://localhost:9000 \
+//		-signed-header X-Authmid-Timestamp
+//
+// With -forward-auth it instead answers the subrequests of ingress
+// controllers such as Nginx's auth_request or Traefik's ForwardAuth,
+// see package forwardauth.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/orijtech/authmid/forwardauth"
+	"github.com/orijtech/authmid/internal/cli"
+	"github.com/orijtech/authmid/proxy"
+)
+
+func main() {
+	log.SetPrefix("authmid-proxy: ")
+
+	addr := flag.String("listen", ":8080", "the address to listen on")
+	upstream := flag.String("upstream", "", "the URL of the service to forward verified requests to")
+	forwardAuth := flag.Bool("forward-auth", false, "answer forward-auth subrequests instead of proxying to -upstream")
+	nginx := flag.Bool("nginx", false, "with -forward-auth, deny with only the statuses that Nginx's auth_request understands")
+	backendSpec := flag.String("backend", os.Getenv("AUTHMID_BACKEND"), "the backend that holds the API keys")
+	table := flag.String("table", "authmid_keys", "the table, hash table or Vault path prefix that holds the keys")
+	keyHeader := flag.String("identity-header", proxy.DefaultKeyHeader, "the header that carries the authenticated API key upstream")
+	var strip cli.StringsFlag
+	flag.Var(&strip, "strip-header", "a header to remove before forwarding; repeatable")
+	var scheme cli.SchemeFlags
+	scheme.Register(flag.CommandLine)
+	flag.Parse()
+
+	backend, err := cli.OpenBackend(*backendSpec, *table)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer backend.Close()
+
+	vf := scheme.Authenticator(backend)
+	var handler http.Handler
+	serving := "forward-auth requests"
+	if *forwardAuth {
+		handler, err = forwardauth.New(&forwardauth.Config{
+			Authenticator: vf,
+			KeyHeader:     *keyHeader,
+			Nginx:         *nginx,
+		})
+	} else {
+		upstreamURL, perr := url.Parse(*upstream)
+		if perr != nil || upstreamURL.Scheme == "" || upstreamURL.Host == "" {
+			log.Fatalf("expecting an absolute -upstream URL, got %q", *upstream)
+		}
+		handler, err = proxy.New(&proxy.Config{
+			Upstream:      upstreamURL,
+			Authenticator: vf,
+			StripHeaders:  strip,
+			KeyHeader:     *keyHeader,
+			ErrorLog:      log.New(os.Stderr, "authmid-proxy: ", log.LstdFlags),
+		})
+		serving = "verified requests for " + upstreamURL.String()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("serving %s on %s", serving, *addr)
+	if err := http.ListenAndServe(*addr, handler); err != nil {
+		log.Fatal(err)
+	}
+}
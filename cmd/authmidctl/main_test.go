@@ -0,0 +1,166 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// run runs a command of c with stdin, returning what it printed.
+func run(c *ctl, stdin string, cmd func(*ctl, []string) error, args ...string) (string, error) {
+	out := new(bytes.Buffer)
+	c.stdin, c.stdout = strings.NewReader(stdin), out
+	err := cmd(c, args)
+	return out.String(), err
+}
+
+// parseCreds parses the key and secret that create and rotate print.
+func parseCreds(t *testing.T, out string) (key, secret string) {
+	for _, line := range strings.Split(out, "\n") {
+		if v := strings.TrimPrefix(line, "key:"); v != line {
+			key = strings.TrimSpace(v)
+		}
+		if v := strings.TrimPrefix(line, "secret:"); v != line {
+			secret = strings.TrimSpace(v)
+		}
+	}
+	if key == "" || secret == "" {
+		t.Fatalf("no credentials in %q", out)
+	}
+	return key, secret
+}
+
+// stored returns the keys persisted in path.
+func stored(t *testing.T, path string) map[string]string {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	m := make(map[string]string)
+	if err := json.Unmarshal(blob, &m); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return m
+}
+
+func TestKeyLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	c := &ctl{backendSpec: "file://" + path}
+
+	out, err := run(c, "", (*ctl).create, "-prefix", "test")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	key, secret := parseCreds(t, out)
+	if !strings.HasPrefix(key, "test") {
+		t.Errorf("create: got key %q without the prefix", key)
+	}
+	if got := stored(t, path); got[key] != secret {
+		t.Errorf("create: got stored keys %v", got)
+	}
+
+	out, err = run(c, "", (*ctl).rotate, key)
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	rotatedKey, rotated := parseCreds(t, out)
+	if rotatedKey != key || rotated == secret {
+		t.Errorf("rotate: got %q %q", rotatedKey, rotated)
+	}
+	if got := stored(t, path); got[key] != rotated {
+		t.Errorf("rotate: got stored keys %v", got)
+	}
+	if _, err := run(c, "", (*ctl).rotate, "absent"); err == nil {
+		t.Errorf("rotate: expecting an error for an absent key")
+	}
+
+	if out, err := run(c, "", (*ctl).list); err != nil || out != key+"\n" {
+		t.Errorf("list: got %q, %v", out, err)
+	}
+
+	out, err = run(c, "", (*ctl).export)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	var keys []string
+	if err := json.Unmarshal([]byte(out), &keys); err != nil || len(keys) != 1 || keys[0] != key {
+		t.Errorf("export: got %q, %v", out, err)
+	}
+	out, err = run(c, "", (*ctl).export, "-with-secrets")
+	if err != nil {
+		t.Fatalf("export -with-secrets: %v", err)
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal([]byte(out), &secrets); err != nil || secrets[key] != rotated {
+		t.Errorf("export -with-secrets: got %q, %v", out, err)
+	}
+
+	if _, err := run(c, "", (*ctl).revoke, key); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if got := stored(t, path); len(got) != 0 {
+		t.Errorf("revoke: got stored keys %v", got)
+	}
+	if _, err := run(c, "", (*ctl).revoke, key); err == nil {
+		t.Errorf("revoke: expecting an error for a revoked key")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	c := &ctl{backendSpec: "file://" + path}
+	out, err := run(c, "", (*ctl).create)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	key, _ := parseCreds(t, out)
+
+	body := `{"id": 7}`
+	// The secret is looked up in the backend.
+	headers, err := run(c, body, (*ctl).sign,
+		"-key", key, "-signed-header", "X-Authmid-Timestamp",
+		"-H", "X-Authmid-Timestamp: 1500000000",
+		"POST", "/v1/orders?page=2")
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	for i, tt := range []struct {
+		body    string
+		wantErr bool
+	}{
+		{body: body},
+		{body: `{"id": 8}`, wantErr: true},
+	} {
+		captured := "POST /v1/orders?page=2 HTTP/1.1\r\nHost: api.example.com\r\n" +
+			headers + "Content-Length: " + strconv.Itoa(len(tt.body)) + "\r\n\r\n" + tt.body
+		out, err := run(c, captured, (*ctl).verify, "-signed-header", "X-Authmid-Timestamp", "-")
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("#%d: verify: expecting an error", i)
+			}
+			continue
+		}
+		if err != nil || out != "OK\n" {
+			t.Errorf("#%d: verify: got %q, %v", i, out, err)
+		}
+	}
+}
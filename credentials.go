@@ -0,0 +1,153 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"strings"
+)
+
+// DefaultKeyPrefix marks generated API keys so that leaked
+// keys can be found by scanning for it e.g. in logs or repositories.
+const DefaultKeyPrefix = "amk"
+
+const (
+	keyRandomBytes    = 20
+	secretRandomBytes = 32
+	checksumLen       = 7 // base32 encoding of a 4 byte CRC-32 without padding
+)
+
+var keyEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+var (
+	ErrMalformedAPIKey  = errors.New("malformed API key")
+	ErrChecksumMismatch = errors.New("API key checksum mismatch")
+
+	errInvalidKeyPrefix = errors.New("expecting a non-empty alphanumeric key prefix")
+	errNilWriteBackend  = errors.New("expecting a non-nil write backend")
+)
+
+type Credentials struct {
+	APIKey string
+	Secret string
+}
+
+// GenerateCredentials returns a new API key carrying DefaultKeyPrefix
+// and a 256 bit secret, both drawn from crypto/rand.
+func GenerateCredentials() (*Credentials, error) {
+	return GenerateCredentialsWithPrefix(DefaultKeyPrefix)
+}
+
+func GenerateCredentialsWithPrefix(prefix string) (*Credentials, error) {
+	if !validKeyPrefix(prefix) {
+		return nil, errInvalidKeyPrefix
+	}
+	keyBytes := make([]byte, keyRandomBytes)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return nil, err
+	}
+	secret, err := GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	body := prefix + "_" + keyEncoding.EncodeToString(keyBytes)
+	creds := &Credentials{
+		APIKey: body + keyChecksum(body),
+		Secret: secret,
+	}
+	return creds, nil
+}
+
+// GenerateSecret returns a hex encoded 256 bit secret, suitable
+// for rotating the secret of an existing API key.
+func GenerateSecret() (string, error) {
+	secretBytes := make([]byte, secretRandomBytes)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secretBytes), nil
+}
+
+// ValidateAPIKey checks the shape and checksum of a key produced by
+// GenerateCredentials, which catches typos without a backend lookup.
+func ValidateAPIKey(apiKey string) error {
+	sep := strings.LastIndex(apiKey, "_")
+	if sep <= 0 || !validKeyPrefix(apiKey[:sep]) {
+		return ErrMalformedAPIKey
+	}
+	rest := apiKey[sep+1:]
+	if len(rest) != keyEncoding.EncodedLen(keyRandomBytes)+checksumLen {
+		return ErrMalformedAPIKey
+	}
+	randomPart, checksum := rest[:len(rest)-checksumLen], rest[len(rest)-checksumLen:]
+	if _, err := keyEncoding.DecodeString(randomPart); err != nil {
+		return ErrMalformedAPIKey
+	}
+	if keyChecksum(apiKey[:len(apiKey)-checksumLen]) != checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+func keyChecksum(body string) string {
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE([]byte(body)))
+	return keyEncoding.EncodeToString(sum)
+}
+
+func validKeyPrefix(prefix string) bool {
+	if prefix == "" {
+		return false
+	}
+	for _, r := range prefix {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Provisioner generates credentials and stores them in a WriteBackend.
+// The secret is only ever handed back from Provision, callers must
+// deliver it to the key holder then because it can't be retrieved again.
+type Provisioner struct {
+	Backend WriteBackend
+
+	// KeyPrefix if set overrides DefaultKeyPrefix.
+	KeyPrefix string
+}
+
+func (p *Provisioner) Provision() (*Credentials, error) {
+	if p == nil || p.Backend == nil {
+		return nil, errNilWriteBackend
+	}
+	prefix := p.KeyPrefix
+	if prefix == "" {
+		prefix = DefaultKeyPrefix
+	}
+	creds, err := GenerateCredentialsWithPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Backend.UpsertSecret(creds.APIKey, creds.Secret); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+)
+
+func TestGenerateCredentials(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		creds, err := authmid.GenerateCredentials()
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if !strings.HasPrefix(creds.APIKey, authmid.DefaultKeyPrefix+"_") {
+			t.Errorf("#%d: %q lacks the %q prefix", i, creds.APIKey, authmid.DefaultKeyPrefix)
+		}
+		if err := authmid.ValidateAPIKey(creds.APIKey); err != nil {
+			t.Errorf("#%d: generated key %q fails validation: %v", i, creds.APIKey, err)
+		}
+		if len(creds.Secret) != 64 {
+			t.Errorf("#%d: secret length: got %d want 64", i, len(creds.Secret))
+		}
+		if seen[creds.APIKey] || seen[creds.Secret] {
+			t.Fatalf("#%d: repeated credentials", i)
+		}
+		seen[creds.APIKey], seen[creds.Secret] = true, true
+	}
+}
+
+func TestValidateAPIKey(t *testing.T) {
+	creds, err := authmid.GenerateCredentialsWithPrefix("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := creds.APIKey
+
+	// Flip one character of the random part to simulate a typo.
+	typo := []byte(key)
+	i := len("acme_") + 3
+	if typo[i] == 'a' {
+		typo[i] = 'b'
+	} else {
+		typo[i] = 'a'
+	}
+
+	tests := [...]struct {
+		key     string
+		wantErr error
+	}{
+		0: {key: key},
+		1: {key: string(typo), wantErr: authmid.ErrChecksumMismatch},
+		2: {key: key[:len(key)-1], wantErr: authmid.ErrMalformedAPIKey},
+		3: {key: "b9b60b63-e37b-4b45-9397-2c20433f4d53", wantErr: authmid.ErrMalformedAPIKey},
+		4: {key: "_" + key[len("acme_"):], wantErr: authmid.ErrMalformedAPIKey},
+		5: {key: "", wantErr: authmid.ErrMalformedAPIKey},
+	}
+
+	for i, tt := range tests {
+		if err := authmid.ValidateAPIKey(tt.key); err != tt.wantErr {
+			t.Errorf("#%d: %q: got err=%v want %v", i, tt.key, err, tt.wantErr)
+		}
+	}
+
+	if _, err := authmid.GenerateCredentialsWithPrefix("no-dashes"); err == nil {
+		t.Errorf("expected an error for a non-alphanumeric prefix")
+	}
+}
+
+func TestProvisioner(t *testing.T) {
+	backend, _ := memory.NewWithMap(make(map[string]string))
+	p := &authmid.Provisioner{Backend: backend, KeyPrefix: "partner"}
+	creds, err := p.Provision()
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if !strings.HasPrefix(creds.APIKey, "partner_") {
+		t.Errorf("%q lacks the %q prefix", creds.APIKey, "partner")
+	}
+	secret, err := backend.LookupSecret(creds.APIKey)
+	if err != nil || string(secret) != creds.Secret {
+		t.Errorf("stored secret: got (%q, %v) want (%q, nil)", secret, err, creds.Secret)
+	}
+
+	if _, err := new(authmid.Provisioner).Provision(); err == nil {
+		t.Errorf("expected an error without a backend")
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DebugHeader carries DebugToken for requests that ask
+// for the details of their signature mismatches.
+const DebugHeader = "Authmid-Debug-Token"
+
+// SignatureDebugger is optionally implemented by an Authenticator
+// to explain signature mismatches to either side.
+type SignatureDebugger interface {
+	SignatureDebugConfig() *DebugConfig
+}
+
+type DebugConfig struct {
+	// Respond makes Checker return a *SignatureMismatchError, whose
+	// message Middleware sends back. Only enable it in non-production
+	// configurations.
+	Respond bool
+
+	// RespondToDebugHeader responds with the details only to requests
+	// whose DebugHeader carries the DebugToken of their API key, i.e.
+	// callers that prove that they hold the secret.
+	RespondToDebugHeader bool
+
+	// Logf if set is invoked with the details of every mismatch.
+	Logf func(format string, args ...interface{})
+}
+
+// DebugToken returns the value of DebugHeader that
+// unlocks mismatch details for apiKey's requests.
+func DebugToken(secret []byte, apiKey string) string {
+	return hmacHex(secret, "authmid-debug:"+apiKey)
+}
+
+// CanonicalRequest describes the input that the server signed. The body
+// is summarized by its length and digest so that it can be safely logged.
+type CanonicalRequest struct {
+	APIKey       string
+	HeaderValues []string
+
+	// SignedHeaders is the rendering of Canonicalization.Headers, if any.
+	SignedHeaders string
+
+	// Method and Path are blank if the
+	// Authenticator excludes them from signatures.
+	Method string
+	Path   string
+
+	BodyLength int
+	BodySHA256 string
+}
+
+func (cr *CanonicalRequest) String() string {
+	lines := []string{
+		fmt.Sprintf("api key: %q", cr.APIKey),
+		fmt.Sprintf("header values: %q", cr.HeaderValues),
+	}
+	if cr.SignedHeaders != "" {
+		lines = append(lines, fmt.Sprintf("signed headers: %q", cr.SignedHeaders))
+	}
+	if cr.Method != "" || cr.Path != "" {
+		lines = append(lines, fmt.Sprintf("method: %q", cr.Method), fmt.Sprintf("path: %q", cr.Path))
+	}
+	lines = append(lines,
+		fmt.Sprintf("body length: %d", cr.BodyLength),
+		fmt.Sprintf("body sha256: %s", cr.BodySHA256),
+		"signed in the order: header values, signed headers, method, path, body",
+	)
+	return strings.Join(lines, "\n")
+}
+
+// SignatureMismatchError is returned instead of ErrSignatureMismatch
+// when the Authenticator's DebugConfig allows it.
+type SignatureMismatchError struct {
+	Canonical *CanonicalRequest
+}
+
+var _ CodedError = (*SignatureMismatchError)(nil)
+
+func (sme *SignatureMismatchError) Error() string {
+	return ErrSignatureMismatch.Error() + "\nthe server computed the signature over:\n" + sme.Canonical.String()
+}
+
+func (sme *SignatureMismatchError) Code() int {
+	return http.StatusBadRequest
+}
+
+// Unwrap makes errors.Is(err, ErrSignatureMismatch) hold.
+func (sme *SignatureMismatchError) Unwrap() error {
+	return ErrSignatureMismatch
+}
+
+func signatureMismatch(vf Authenticator, req *http.Request, apiKey string, apiSecret []byte, cr *canonicalRequest) error {
+	sd, ok := vf.(SignatureDebugger)
+	if !ok {
+		return ErrSignatureMismatch
+	}
+	cfg := sd.SignatureDebugConfig()
+	if cfg == nil {
+		return ErrSignatureMismatch
+	}
+
+	bodySum := sha256.Sum256(cr.body)
+	details := &CanonicalRequest{
+		APIKey:        apiKey,
+		HeaderValues:  cr.headerValues,
+		SignedHeaders: cr.signedHeaders,
+		Method:        cr.method,
+		Path:          cr.path,
+		BodyLength:    len(cr.body),
+		BodySHA256:    fmt.Sprintf("%x", bodySum),
+	}
+	if cfg.Logf != nil {
+		cfg.Logf("authmid: signature mismatch for %s %s, the server signed:\n%s", req.Method, req.URL, details)
+	}
+
+	respond := cfg.Respond
+	if !respond && cfg.RespondToDebugHeader {
+		token := req.Header.Get(DebugHeader)
+		respond = token != "" && hmac.Equal([]byte(token), []byte(DebugToken(apiSecret, apiKey)))
+	}
+	if !respond {
+		return ErrSignatureMismatch
+	}
+	return &SignatureMismatchError{Canonical: details}
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/orijtech/authmid"
+)
+
+type debugAuthChecker struct {
+	sampleAuthChecker
+	cfg *authmid.DebugConfig
+}
+
+func (da *debugAuthChecker) SignatureDebugConfig() *authmid.DebugConfig {
+	return da.cfg
+}
+
+func TestSignatureMismatchDebugging(t *testing.T) {
+	tests := [...]struct {
+		cfg         *authmid.DebugConfig
+		debugToken  string
+		wantDetails bool
+	}{
+		0: {cfg: nil},
+		1: {cfg: &authmid.DebugConfig{Respond: true}, wantDetails: true},
+		2: {cfg: &authmid.DebugConfig{RespondToDebugHeader: true}},
+		3: {
+			cfg:         &authmid.DebugConfig{RespondToDebugHeader: true},
+			debugToken:  authmid.DebugToken(bAPISecret1, apiKey1),
+			wantDetails: true,
+		},
+		4: {
+			cfg:        &authmid.DebugConfig{RespondToDebugHeader: true},
+			debugToken: authmid.DebugToken(bAPISecret2, apiKey1),
+		},
+		5: {cfg: &authmid.DebugConfig{}},
+	}
+
+	for i, tt := range tests {
+		req := makeReq("POST", []byte(`{"name": "foo"}`), authKey1)
+		// Tamper with the path after signing.
+		req.URL.Path = "/tampered"
+		if tt.debugToken != "" {
+			req.Header.Set(authmid.DebugHeader, tt.debugToken)
+		}
+		var logged []string
+		if tt.cfg != nil {
+			tt.cfg.Logf = func(format string, args ...interface{}) {
+				logged = append(logged, fmt.Sprintf(format, args...))
+			}
+		}
+
+		err := authmid.Checker(&debugAuthChecker{cfg: tt.cfg})(req)
+		if !errors.Is(err, authmid.ErrSignatureMismatch) {
+			t.Errorf("#%d: got err=%v want %v", i, err, authmid.ErrSignatureMismatch)
+			continue
+		}
+		sme, gotDetails := err.(*authmid.SignatureMismatchError)
+		if gotDetails != tt.wantDetails {
+			t.Errorf("#%d: gotDetails=%v wantDetails=%v", i, gotDetails, tt.wantDetails)
+		}
+		if gotDetails {
+			if sme.Canonical.Path != "/tampered" || sme.Canonical.Method != "POST" || sme.Canonical.APIKey != apiKey1 {
+				t.Errorf("#%d: unexpected details: %+v", i, sme.Canonical)
+			}
+			if sme.Canonical.BodyLength != len(`{"name": "foo"}`) {
+				t.Errorf("#%d: body length: got %d", i, sme.Canonical.BodyLength)
+			}
+			if strings.Contains(err.Error(), string(bAPISecret1)) || strings.Contains(err.Error(), `"foo"`) {
+				t.Errorf("#%d: details leak the secret or body: %s", i, err)
+			}
+		}
+		if tt.cfg != nil && len(logged) != 1 {
+			t.Errorf("#%d: got %d log lines want 1", i, len(logged))
+		}
+	}
+}
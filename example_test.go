@@ -52,6 +52,26 @@ func Example_inPlainServer() {
 	})))
 }
 
+func Example_headerAuthenticator() {
+	backend, err := redis.New("keys", "redis://localhost:6379")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auth := &authmid.HeaderAuthenticator{
+		Backend:         backend,
+		KeyHeader:       "DEMO-ACCESS-KEY",
+		SignatureHeader: "DEMO-ACCESS-SIGN",
+		Headers: []authmid.HeaderSpec{
+			{Name: "DEMO-ACCESS-TIMESTAMP"},
+			{Name: "DEMO-VERSION", Optional: true},
+		},
+	}
+	http.Handle("/ping", authmid.Middleware(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Authenticated pong!")
+	})))
+}
+
 func Example_backendForAuthentication() {
 	backend, err := redis.New("keys", "redis://localhost:6379")
 	if err != nil {
@@ -67,13 +87,19 @@ func Example_backendForAuthentication() {
 	}
 	http.Handle("/ping", ac)
 
+	provisioner := &authmid.Provisioner{Backend: backend}
 	http.HandleFunc("/reg", func(w http.ResponseWriter, r *http.Request) {
-		qv := r.URL.Query()
-		key, secret := qv.Get("key"), qv.Get("secret")
-		if err := backend.UpsertSecret(key, secret); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if r.Method != "POST" {
+			http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		creds, err := provisioner.Provision()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		// This is the only time that the secret is ever revealed.
+		fmt.Fprintf(w, "key: %s\nsecret: %s\n", creds.APIKey, creds.Secret)
 	})
 
 	addr := ":8777"
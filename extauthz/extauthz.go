@@ -0,0 +1,206 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package extauthz implements Envoy's external authorization service,
+// envoy.service.auth.v3.Authorization, for the ext_authz filter to verify
+// requests at the edge of a service mesh.
+//
+// Each CheckRequest's method, host, scheme, path, headers and body are
+// verified as the original request. Envoy only sends the body if the filter is configured
+// with with_request_body, signatures over the body need it. Envoy joins the
+// values of repeated headers with commas unless encode_raw_headers is set.
+package extauthz
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/proxy"
+)
+
+type Config struct {
+	Authenticator authmid.Authenticator
+
+	// KeyHeader and ScopesHeader are set on allowed requests to the
+	// authenticated API key and its comma separated scopes, they default
+	// to proxy.DefaultKeyHeader and proxy.DefaultScopesHeader. Clients'
+	// own values are always dropped.
+	KeyHeader    string
+	ScopesHeader string
+
+	// StripHeaders are removed from allowed requests, typically the
+	// credentials that upstream has no use for, see CredentialHeaders
+	// of *authmid.HeaderAuthenticator.
+	StripHeaders []string
+
+	// Throttle if set locks out API keys and client IPs whose checks fail
+	// too often, like authmid.ThrottledMiddleware. The client IP is the
+	// source address that Envoy reports, Throttle.ClientIP is unused.
+	Throttle *authmid.ThrottleConfig
+}
+
+var (
+	errNilConfig        = errors.New("expecting a non-nil config")
+	errNilAuthenticator = errors.New("expecting a non-nil authenticator")
+	errNoHTTPRequest    = errors.New("expecting the attributes of an HTTP request")
+	errNilTracker       = errors.New("expecting a non-nil failure tracker")
+)
+
+// New returns an Authorization server, to register on a *grpc.Server
+// with authv3.RegisterAuthorizationServer.
+func New(cfg *Config) (authv3.AuthorizationServer, error) {
+	if cfg == nil {
+		return nil, errNilConfig
+	}
+	if cfg.Authenticator == nil {
+		return nil, errNilAuthenticator
+	}
+	if cfg.Throttle != nil && cfg.Throttle.Tracker == nil {
+		return nil, errNilTracker
+	}
+	s := &server{Config: *cfg}
+	if s.KeyHeader == "" {
+		s.KeyHeader = proxy.DefaultKeyHeader
+	}
+	if s.ScopesHeader == "" {
+		s.ScopesHeader = proxy.DefaultScopesHeader
+	}
+	return s, nil
+}
+
+type server struct {
+	authv3.UnimplementedAuthorizationServer
+	Config
+}
+
+// Check denies requests that fail verification, that's not an error of
+// the call itself which only fails if req has no HTTP request attributes.
+func (s *server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	hr := req.GetAttributes().GetRequest().GetHttp()
+	if hr == nil {
+		return nil, errNoHTTPRequest
+	}
+	msg := message(hr)
+	verify := func() (*authmid.Principal, error) {
+		return authmid.Verify(ctx, s.Authenticator, msg)
+	}
+	var principal *authmid.Principal
+	var err error
+	if s.Throttle != nil {
+		apiKey, _ := s.Authenticator.LookupAPIKey(msg.Header)
+		ip := req.GetAttributes().GetSource().GetAddress().GetSocketAddress().GetAddress()
+		principal, err = s.Throttle.Throttle(apiKey, ip, verify)
+	} else {
+		principal, err = verify()
+	}
+	if err != nil {
+		return denied(err), nil
+	}
+	return s.allowed(principal), nil
+}
+
+// message returns the authmid.Message of the original request.
+func message(hr *authv3.AttributeContext_HttpRequest) *authmid.Message {
+	hdr := make(http.Header)
+	add := func(key, value string) {
+		// Skip HTTP/2 pseudo-headers such as :path and :method.
+		if !strings.HasPrefix(key, ":") {
+			hdr.Add(key, value)
+		}
+	}
+	if hm := hr.GetHeaderMap(); hm != nil {
+		for _, hv := range hm.GetHeaders() {
+			value := hv.GetValue()
+			if raw := hv.GetRawValue(); len(raw) > 0 {
+				value = string(raw)
+			}
+			add(hv.GetKey(), value)
+		}
+	} else {
+		for key, value := range hr.GetHeaders() {
+			add(key, value)
+		}
+	}
+	body := hr.GetRawBody()
+	if len(body) == 0 {
+		body = []byte(hr.GetBody())
+	}
+	return &authmid.Message{
+		Method: hr.GetMethod(),
+		// Envoy's path includes the query.
+		Target: hr.GetPath(),
+		Header: hdr,
+		Body:   bytes.NewReader(body),
+		Host:   hr.GetHost(),
+		Scheme: hr.GetScheme(),
+	}
+}
+
+func (s *server) allowed(principal *authmid.Principal) *authv3.CheckResponse {
+	ok := &authv3.OkHttpResponse{
+		Headers:         []*corev3.HeaderValueOption{setHeader(s.KeyHeader, principal.APIKey)},
+		HeadersToRemove: append([]string(nil), s.StripHeaders...),
+	}
+	if len(principal.Scopes) > 0 {
+		ok.Headers = append(ok.Headers, setHeader(s.ScopesHeader, strings.Join(principal.Scopes, ",")))
+	} else {
+		ok.HeadersToRemove = append(ok.HeadersToRemove, s.ScopesHeader)
+	}
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: ok},
+	}
+}
+
+func setHeader(key, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: key, Value: value},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
+
+func denied(err error) *authv3.CheckResponse {
+	code, httpStatus := codes.Unauthenticated, http.StatusUnauthorized
+	switch status := authmid.ErrorStatus(err); status {
+	case http.StatusForbidden:
+		code, httpStatus = codes.PermissionDenied, status
+	case http.StatusTooManyRequests:
+		code, httpStatus = codes.ResourceExhausted, status
+	case http.StatusInternalServerError:
+		code, httpStatus = codes.Internal, status
+	}
+	reason := authmid.ErrorMessage(err)
+	deniedResponse := &authv3.DeniedHttpResponse{
+		Status: &typev3.HttpStatus{Code: typev3.StatusCode(httpStatus)},
+		Body:   reason,
+	}
+	var le *authmid.LockedOutError
+	if errors.As(err, &le) {
+		deniedResponse.Headers = append(deniedResponse.Headers, setHeader("Retry-After", authmid.RetryAfter(le.RetryAfter)))
+	}
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(code), Message: reason},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: deniedResponse},
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extauthz_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc/codes"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+	"github.com/orijtech/authmid/extauthz"
+)
+
+func newScheme() *authmid.HeaderAuthenticator {
+	backend, _ := memory.NewWithMap(map[string]string{"partner": "partner-secret"})
+	backend.SetScopes("partner", []string{"orders:read"})
+	return &authmid.HeaderAuthenticator{
+		Backend:         backend,
+		KeyHeader:       "X-Authmid-Key",
+		SignatureHeader: "X-Authmid-Signature",
+		Headers:         []authmid.HeaderSpec{{Name: "X-Authmid-Timestamp"}},
+	}
+}
+
+// envoyRequest describes the CheckRequest that Envoy sends for a signed
+// POST with a body.
+type envoyRequest struct {
+	secret string
+
+	// signedPath is the path that was signed, by default path, which
+	// defaults to "/v1/orders?page=2".
+	path, signedPath string
+
+	// signedHost is the host that was signed, by default host, which
+	// defaults to "api.example.com". The scheme is always https.
+	host, signedHost string
+
+	// headerMap sends the headers as a header map, as Envoy does with
+	// encode_raw_headers, rather than joining repeated ones with commas.
+	headerMap bool
+
+	// noBody leaves out the body, as Envoy does without with_request_body.
+	noBody bool
+
+	// timestamps are the values of the signed X-Authmid-Timestamp header,
+	// by default just one.
+	timestamps []string
+}
+
+func (er *envoyRequest) checkRequest(t *testing.T, ha *authmid.HeaderAuthenticator) *authv3.CheckRequest {
+	path := er.path
+	if path == "" {
+		path = "/v1/orders?page=2"
+	}
+	signedPath := er.signedPath
+	if signedPath == "" {
+		signedPath = path
+	}
+	host := er.host
+	if host == "" {
+		host = "api.example.com"
+	}
+	signedHost := er.signedHost
+	if signedHost == "" {
+		signedHost = host
+	}
+	timestamps := er.timestamps
+	if len(timestamps) == 0 {
+		timestamps = []string{"1500000000"}
+	}
+	body := `{"id": 7}`
+
+	req := httptest.NewRequest("POST", signedPath, bytes.NewReader([]byte(body)))
+	req.Host, req.URL.Scheme = signedHost, "https"
+	req.Header["X-Authmid-Timestamp"] = timestamps
+	if err := ha.SignRequest(req, "partner", []byte(er.secret)); err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+	hr := &authv3.AttributeContext_HttpRequest{Method: "POST", Path: path, Host: host, Scheme: "https"}
+	if !er.noBody {
+		hr.RawBody = []byte(body)
+	}
+	headers := map[string][]string{
+		":path":      {hr.Path},
+		":method":    {hr.Method},
+		":authority": {hr.Host},
+		":scheme":    {hr.Scheme},
+	}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = values
+	}
+	if er.headerMap {
+		hr.HeaderMap = new(corev3.HeaderMap)
+		for key, values := range headers {
+			for _, value := range values {
+				hr.HeaderMap.Headers = append(hr.HeaderMap.Headers, &corev3.HeaderValue{Key: key, RawValue: []byte(value)})
+			}
+		}
+	} else {
+		hr.Headers = make(map[string]string)
+		for key, values := range headers {
+			hr.Headers[key] = strings.Join(values, ",")
+		}
+	}
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Request: &authv3.AttributeContext_Request{Http: hr},
+	}}
+}
+
+func TestCheck(t *testing.T) {
+	ha := newScheme()
+	srv, err := extauthz.New(&extauthz.Config{Authenticator: ha, StripHeaders: ha.CredentialHeaders()})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	tests := [...]struct {
+		req        envoyRequest
+		wantCode   codes.Code
+		wantStatus int
+	}{
+		0: {req: envoyRequest{secret: "partner-secret"}, wantCode: codes.OK},
+		1: {req: envoyRequest{secret: "partner-secret", headerMap: true}, wantCode: codes.OK},
+		2: {req: envoyRequest{secret: "guessed"}, wantCode: codes.Unauthenticated, wantStatus: http.StatusUnauthorized},
+		// The signature covers the body, which Envoy must send.
+		3: {req: envoyRequest{secret: "partner-secret", noBody: true}, wantCode: codes.Unauthenticated, wantStatus: http.StatusUnauthorized},
+		// Repeated headers verify only as a header map, joined they're
+		// a different value than the first one that was signed.
+		4: {req: envoyRequest{secret: "partner-secret", headerMap: true, timestamps: []string{"1500000000", "1600000000"}}, wantCode: codes.OK},
+		5: {req: envoyRequest{secret: "partner-secret", timestamps: []string{"1500000000", "1600000000"}}, wantCode: codes.Unauthenticated, wantStatus: http.StatusUnauthorized},
+		// Paths that start with "//" are paths, not a host and a path.
+		6: {req: envoyRequest{secret: "partner-secret", path: "//a/b?page=2"}, wantCode: codes.OK},
+		7: {req: envoyRequest{secret: "partner-secret", path: "//zzz/b?page=2", signedPath: "//a/b?page=2"}, wantCode: codes.Unauthenticated, wantStatus: http.StatusUnauthorized},
+	}
+
+	for i, tt := range tests {
+		res, err := srv.Check(context.Background(), tt.req.checkRequest(t, ha))
+		if err != nil {
+			t.Errorf("#%d: Check: %v", i, err)
+			continue
+		}
+		if got := codes.Code(res.GetStatus().GetCode()); got != tt.wantCode {
+			t.Errorf("#%d: got %v want %v; status: %v", i, got, tt.wantCode, res.GetStatus())
+			continue
+		}
+		if tt.wantCode != codes.OK {
+			if got := int(res.GetDeniedResponse().GetStatus().GetCode()); got != tt.wantStatus {
+				t.Errorf("#%d: got HTTP status %d want %d", i, got, tt.wantStatus)
+			}
+			continue
+		}
+		ok := res.GetOkResponse()
+		got := make(map[string]string)
+		for _, hvo := range ok.GetHeaders() {
+			if hvo.GetAppendAction() != corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD {
+				t.Errorf("#%d: %s is appended to, not overwritten", i, hvo.GetHeader().GetKey())
+			}
+			got[hvo.GetHeader().GetKey()] = hvo.GetHeader().GetValue()
+		}
+		if got["X-Authenticated-Key"] != "partner" || got["X-Authenticated-Scopes"] != "orders:read" {
+			t.Errorf("#%d: got headers %v", i, got)
+		}
+		removed := append([]string(nil), ok.GetHeadersToRemove()...)
+		sort.Strings(removed)
+		if g, w := strings.Join(removed, ","), "X-Authmid-Key,X-Authmid-Signature"; g != w {
+			t.Errorf("#%d: got headers to remove %q want %q", i, g, w)
+		}
+	}
+
+	if _, err := srv.Check(context.Background(), &authv3.CheckRequest{}); err == nil {
+		t.Errorf("expecting an error without HTTP request attributes")
+	}
+}
+
+func TestCheckSignedHost(t *testing.T) {
+	ha := newScheme()
+	ha.Canonical.Headers = &authmid.SignedHeaders{Names: []string{authmid.SignedHost, authmid.SignedScheme}}
+	srv, err := extauthz.New(&extauthz.Config{Authenticator: ha})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	tests := [...]struct {
+		req      envoyRequest
+		wantCode codes.Code
+	}{
+		0: {req: envoyRequest{secret: "partner-secret"}, wantCode: codes.OK},
+		1: {req: envoyRequest{secret: "partner-secret", host: "API.example.com", signedHost: "api.example.com"}, wantCode: codes.OK},
+		2: {req: envoyRequest{secret: "partner-secret", host: "internal.example.com", signedHost: "api.example.com"}, wantCode: codes.Unauthenticated},
+	}
+
+	for i, tt := range tests {
+		res, err := srv.Check(context.Background(), tt.req.checkRequest(t, ha))
+		if err != nil {
+			t.Errorf("#%d: Check: %v", i, err)
+			continue
+		}
+		if got := codes.Code(res.GetStatus().GetCode()); got != tt.wantCode {
+			t.Errorf("#%d: got %v want %v; status: %v", i, got, tt.wantCode, res.GetStatus())
+		}
+	}
+}
+
+func TestCheckThrottled(t *testing.T) {
+	ha := newScheme()
+	srv, err := extauthz.New(&extauthz.Config{
+		Authenticator: ha,
+		Throttle: &authmid.ThrottleConfig{
+			Tracker: memory.NewFailureTracker(),
+			Policy:  authmid.FailurePolicy{MaxFailures: 2, Window: time.Minute, Lockout: 30 * time.Second},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	check := func(secret string) *authv3.CheckResponse {
+		er := &envoyRequest{secret: secret}
+		req := er.checkRequest(t, ha)
+		req.Attributes.Source = &authv3.AttributeContext_Peer{Address: &corev3.Address{
+			Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{Address: "192.0.2.7"}},
+		}}
+		res, err := srv.Check(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+		return res
+	}
+
+	for i := 0; i < 2; i++ {
+		if got := codes.Code(check("guessed").GetStatus().GetCode()); got != codes.Unauthenticated {
+			t.Fatalf("#%d: got %v want %v", i, got, codes.Unauthenticated)
+		}
+	}
+	// Even the right secret is turned away now.
+	res := check("partner-secret")
+	if got := codes.Code(res.GetStatus().GetCode()); got != codes.ResourceExhausted {
+		t.Errorf("got %v want %v", got, codes.ResourceExhausted)
+	}
+	denied := res.GetDeniedResponse()
+	if got := int(denied.GetStatus().GetCode()); got != http.StatusTooManyRequests {
+		t.Errorf("got HTTP status %d want %d", got, http.StatusTooManyRequests)
+	}
+	var retryAfter string
+	for _, hvo := range denied.GetHeaders() {
+		if hvo.GetHeader().GetKey() == "Retry-After" {
+			retryAfter = hvo.GetHeader().GetValue()
+		}
+	}
+	if retryAfter != "30" {
+		t.Errorf("got Retry-After %q want %q", retryAfter, "30")
+	}
+
+	if _, err := extauthz.New(&extauthz.Config{Authenticator: ha, Throttle: &authmid.ThrottleConfig{}}); err == nil {
+		t.Errorf("expecting an error without a failure tracker")
+	}
+}
+
+// brokenTracker fails like a tracker whose database is down.
+type brokenTracker struct{}
+
+func (brokenTracker) Fail(string, authmid.FailurePolicy) (bool, error) {
+	return false, errors.New("dial tcp 10.0.0.7:6379: connection refused")
+}
+
+func (brokenTracker) LockedOut(string) (time.Duration, error) {
+	return 0, errors.New("dial tcp 10.0.0.7:6379: connection refused")
+}
+
+func TestCheckInternalError(t *testing.T) {
+	ha := newScheme()
+	srv, err := extauthz.New(&extauthz.Config{Authenticator: ha, Throttle: &authmid.ThrottleConfig{Tracker: brokenTracker{}}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	er := &envoyRequest{secret: "partner-secret"}
+	res, err := srv.Check(context.Background(), er.checkRequest(t, ha))
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if got := codes.Code(res.GetStatus().GetCode()); got != codes.Internal {
+		t.Errorf("got %v want %v", got, codes.Internal)
+	}
+	denied := res.GetDeniedResponse()
+	if got := int(denied.GetStatus().GetCode()); got != http.StatusInternalServerError {
+		t.Errorf("got HTTP status %d want %d", got, http.StatusInternalServerError)
+	}
+	for _, text := range []string{denied.GetBody(), res.GetStatus().GetMessage()} {
+		if strings.Contains(text, "10.0.0.7") {
+			t.Errorf("the tracker's error was leaked: %q", text)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package forwardauth verifies requests on behalf of ingress controllers
+// that ask an external service to approve them, such as Nginx's
+// auth_request, Traefik's ForwardAuth or Envoy's HTTP ext_authz filter.
+//
+// The original request is reconstructed from the subrequest: its method
+// from X-Forwarded-Method or X-Original-Method, its URI from X-Original-URI
+// or X-Forwarded-Uri, falling back to the subrequest's own, and its body
+// from the subrequest's body, which the controller must be configured to
+// pass along for signatures over the body to verify.
+package forwardauth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/proxy"
+)
+
+type Config struct {
+	Authenticator authmid.Authenticator
+
+	// KeyHeader and ScopesHeader are set on approvals to the authenticated
+	// API key and its comma separated scopes, for the controller to copy
+	// upstream. They default to proxy.DefaultKeyHeader and
+	// proxy.DefaultScopesHeader.
+	KeyHeader    string
+	ScopesHeader string
+
+	// PathPrefix is trimmed from the path of subrequests that don't carry
+	// the original URI in a header, such as those of Envoy's path_prefix.
+	PathPrefix string
+
+	// Nginx narrows the statuses of denials down to those that Nginx's
+	// auth_request understands, 401, 403 and 500, since it treats the
+	// rest as 500. Otherwise denials are answered like by
+	// authmid.Middleware, e.g. with 429 and Retry-After for lockouts.
+	Nginx bool
+}
+
+var (
+	errNilConfig        = errors.New("expecting a non-nil config")
+	errNilAuthenticator = errors.New("expecting a non-nil authenticator")
+)
+
+// New returns a handler that approves subrequests for verified requests
+// with 200 and the identity headers, and otherwise denies them like
+// authmid.Middleware, see Config.Nginx. Requests are verified by
+// authmid.Middleware, so that Audit and the instrumentation see them.
+func New(cfg *Config) (http.Handler, error) {
+	if cfg == nil {
+		return nil, errNilConfig
+	}
+	if cfg.Authenticator == nil {
+		return nil, errNilAuthenticator
+	}
+	fa := &forwardAuth{Config: *cfg}
+	if fa.KeyHeader == "" {
+		fa.KeyHeader = proxy.DefaultKeyHeader
+	}
+	if fa.ScopesHeader == "" {
+		fa.ScopesHeader = proxy.DefaultScopesHeader
+	}
+	fa.verify = authmid.Middleware(fa.Authenticator, http.HandlerFunc(fa.approve))
+	return fa, nil
+}
+
+type forwardAuth struct {
+	Config
+
+	verify http.Handler
+}
+
+func (fa *forwardAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if fa.Nginx {
+		w = nginxWriter{w}
+	}
+	orig, err := fa.original(r)
+	if err != nil {
+		// Whatever sent it can't be vouched for.
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	fa.verify.ServeHTTP(w, orig)
+}
+
+func (fa *forwardAuth) approve(w http.ResponseWriter, r *http.Request) {
+	principal, _ := authmid.PrincipalFromContext(r.Context())
+	w.Header().Set(fa.KeyHeader, principal.APIKey)
+	if len(principal.Scopes) > 0 {
+		w.Header().Set(fa.ScopesHeader, strings.Join(principal.Scopes, ","))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// nginxWriter narrows the statuses of denials down to those
+// that Nginx's auth_request understands.
+type nginxWriter struct {
+	http.ResponseWriter
+}
+
+func (nw nginxWriter) WriteHeader(code int) {
+	switch code {
+	case http.StatusOK, http.StatusForbidden, http.StatusInternalServerError:
+	default:
+		code = http.StatusUnauthorized
+	}
+	nw.ResponseWriter.WriteHeader(code)
+}
+
+// original reconstructs the request that r asks to approve.
+func (fa *forwardAuth) original(r *http.Request) (*http.Request, error) {
+	orig := r.Clone(r.Context())
+	if method := firstHeader(r.Header, "X-Forwarded-Method", "X-Original-Method"); method != "" {
+		orig.Method = method
+	}
+	if uri := firstHeader(r.Header, "X-Original-URI", "X-Forwarded-Uri"); uri != "" {
+		u, err := url.ParseRequestURI(uri)
+		if err != nil {
+			return nil, err
+		}
+		orig.URL = u
+		orig.RequestURI = uri
+	} else if fa.PathPrefix != "" {
+		orig.URL.Path = strings.TrimPrefix(orig.URL.Path, fa.PathPrefix)
+		orig.URL.RawPath = ""
+		orig.RequestURI = orig.URL.RequestURI()
+	}
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		orig.Host = host
+	}
+	orig.URL.Host = orig.Host
+	orig.URL.Scheme = r.Header.Get("X-Forwarded-Proto")
+	return orig, nil
+}
+
+func firstHeader(hdr http.Header, names ...string) string {
+	for _, name := range names {
+		if value := hdr.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,180 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forwardauth_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+	"github.com/orijtech/authmid/forwardauth"
+)
+
+func TestForwardAuth(t *testing.T) {
+	backend, _ := memory.NewWithMap(map[string]string{"partner": "partner-secret"})
+	backend.SetScopes("partner", []string{"orders:read"})
+	ha := &authmid.HeaderAuthenticator{
+		Backend:         backend,
+		KeyHeader:       "X-Authmid-Key",
+		SignatureHeader: "X-Authmid-Signature",
+		Headers:         []authmid.HeaderSpec{{Name: "X-Authmid-Timestamp"}},
+	}
+	handler, err := forwardauth.New(&forwardauth.Config{Authenticator: ha, PathPrefix: "/authz"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	nginx, err := forwardauth.New(&forwardauth.Config{Authenticator: ha, Nginx: true})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	body := `{"id": 7}`
+	// signed returns the headers of a signed POST /v1/orders?page=2.
+	signed := func(secret string) http.Header {
+		req := httptest.NewRequest("POST", "/v1/orders?page=2", bytes.NewReader([]byte(body)))
+		req.Header.Set("X-Authmid-Timestamp", "1500000000")
+		if err := ha.SignRequest(req, "partner", []byte(secret)); err != nil {
+			t.Fatalf("SignRequest: %v", err)
+		}
+		return req.Header
+	}
+
+	tests := [...]struct {
+		method, target string
+		forwarded      map[string]string
+		secret         string
+		nginx          bool
+		wantCode       int
+	}{
+		// Nginx's auth_request.
+		0: {
+			method: "GET", target: "/auth",
+			forwarded: map[string]string{"X-Original-Method": "POST", "X-Original-URI": "/v1/orders?page=2"},
+			secret:    "partner-secret", wantCode: http.StatusOK,
+		},
+		// Traefik's ForwardAuth.
+		1: {
+			method: "GET", target: "/",
+			forwarded: map[string]string{"X-Forwarded-Method": "POST", "X-Forwarded-Uri": "/v1/orders?page=2", "X-Forwarded-Host": "api.example.com"},
+			secret:    "partner-secret", wantCode: http.StatusOK,
+		},
+		// Envoy's ext_authz, which keeps the method and prefixes the path.
+		2: {method: "POST", target: "/authz/v1/orders?page=2", secret: "partner-secret", wantCode: http.StatusOK},
+		3: {
+			method: "GET", target: "/auth",
+			forwarded: map[string]string{"X-Original-Method": "POST", "X-Original-URI": "/v1/orders?page=2"},
+			secret:    "guessed", nginx: true, wantCode: http.StatusUnauthorized,
+		},
+		// The signature covers the method.
+		4: {
+			method: "GET", target: "/auth",
+			forwarded: map[string]string{"X-Original-Method": "PUT", "X-Original-URI": "/v1/orders?page=2"},
+			secret:    "partner-secret", nginx: true, wantCode: http.StatusUnauthorized,
+		},
+		// Unless narrowed for Nginx, the statuses are authmid.Middleware's.
+		5: {
+			method: "GET", target: "/auth",
+			forwarded: map[string]string{"X-Original-Method": "POST", "X-Original-URI": "/v1/orders?page=2"},
+			secret:    "guessed", wantCode: http.StatusBadRequest,
+		},
+		6: {
+			method: "GET", target: "/auth",
+			forwarded: map[string]string{"X-Original-Method": "POST", "X-Original-URI": "v1/orders"},
+			secret:    "partner-secret", wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, bytes.NewReader([]byte(body)))
+		req.Header = signed(tt.secret)
+		for key, value := range tt.forwarded {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		if tt.nginx {
+			nginx.ServeHTTP(rec, req)
+		} else {
+			handler.ServeHTTP(rec, req)
+		}
+		if rec.Code != tt.wantCode {
+			t.Errorf("#%d: got %d want %d; body: %s", i, rec.Code, tt.wantCode, rec.Body)
+			continue
+		}
+		wantKey, wantScopes := "", ""
+		if tt.wantCode == http.StatusOK {
+			wantKey, wantScopes = "partner", "orders:read"
+		}
+		if k, s := rec.Header().Get("X-Authenticated-Key"), rec.Header().Get("X-Authenticated-Scopes"); k != wantKey || s != wantScopes {
+			t.Errorf("#%d: got identity %q %q want %q %q", i, k, s, wantKey, wantScopes)
+		}
+	}
+}
+
+// lockedOut locks every API key out.
+type lockedOut struct {
+	*authmid.HeaderAuthenticator
+}
+
+func (lockedOut) LookupSecretContext(context.Context, string) ([]byte, error) {
+	return nil, &authmid.LockedOutError{RetryAfter: 30 * time.Second}
+}
+
+type sinkFunc func(*authmid.AuditEvent)
+
+func (f sinkFunc) Audit(ev *authmid.AuditEvent) { f(ev) }
+
+func TestForwardAuthLockouts(t *testing.T) {
+	backend, _ := memory.NewWithMap(map[string]string{"partner": "partner-secret"})
+	ha := &authmid.HeaderAuthenticator{Backend: backend, KeyHeader: "X-Authmid-Key", SignatureHeader: "X-Authmid-Signature"}
+
+	for i, tt := range []struct {
+		nginx          bool
+		wantCode       int
+		wantRetryAfter string
+	}{
+		{wantCode: http.StatusTooManyRequests, wantRetryAfter: "30"},
+		{nginx: true, wantCode: http.StatusUnauthorized, wantRetryAfter: "30"},
+	} {
+		fa, err := forwardauth.New(&forwardauth.Config{Authenticator: lockedOut{ha}, Nginx: tt.nginx})
+		if err != nil {
+			t.Fatalf("#%d: New: %v", i, err)
+		}
+		var events []*authmid.AuditEvent
+		handler, _ := authmid.Audit(&authmid.AuditConfig{Sink: sinkFunc(func(ev *authmid.AuditEvent) {
+			events = append(events, ev)
+		})}, fa)
+
+		req := httptest.NewRequest("GET", "/auth", nil)
+		req.Header.Set("X-Original-Method", "DELETE")
+		req.Header.Set("X-Original-URI", "/v1/orders/7")
+		if err := ha.SignRequest(req, "partner", []byte("partner-secret")); err != nil {
+			t.Fatalf("#%d: SignRequest: %v", i, err)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode || rec.Header().Get("Retry-After") != tt.wantRetryAfter {
+			t.Errorf("#%d: got %d, Retry-After %q want %d, %q", i, rec.Code, rec.Header().Get("Retry-After"), tt.wantCode, tt.wantRetryAfter)
+		}
+		// The original request is audited, not the subrequest.
+		if len(events) != 1 || events[0].Outcome != authmid.Denied || events[0].Method != "DELETE" || events[0].Path != "/v1/orders/7" {
+			t.Errorf("#%d: got audit events %+v", i, events)
+		}
+	}
+}
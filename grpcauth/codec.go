@@ -0,0 +1,171 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcauth
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"google.golang.org/grpc/stats"
+	"google.golang.org/protobuf/proto"
+)
+
+var errNotProtoMessage = errors.New("expecting a protobuf message")
+
+// clientCodec is the proto codec, except that it encodes deterministically
+// so that it sends the same bytes that UnaryClientInterceptor signed, and
+// frames the messages that StreamClientInterceptor signed.
+type clientCodec struct{}
+
+func (clientCodec) Name() string { return "proto" }
+
+func (clientCodec) Marshal(v interface{}) ([]byte, error) {
+	if sm, ok := v.(*signedMessage); ok {
+		return sm.frame(), nil
+	}
+	return marshal(v)
+}
+
+func (clientCodec) Unmarshal(data []byte, v interface{}) error {
+	return unmarshal(data, v)
+}
+
+func marshal(msg interface{}) ([]byte, error) {
+	pm, ok := msg.(proto.Message)
+	if !ok {
+		return nil, errNotProtoMessage
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(pm)
+}
+
+func unmarshal(data []byte, msg interface{}) error {
+	pm, ok := msg.(proto.Message)
+	if !ok {
+		return errNotProtoMessage
+	}
+	return proto.Unmarshal(data, pm)
+}
+
+// serverCodec is the proto codec, except that it keeps the bytes that
+// requests were received as, since codecs aren't given the context. It
+// hands them, by message, to the receiptHandler that it was made with,
+// which gRPC tells of each message right after it is decoded.
+type serverCodec struct {
+	decoded *sync.Map
+}
+
+func newServerCodec() (serverCodec, receiptHandler) {
+	decoded := new(sync.Map)
+	return serverCodec{decoded: decoded}, receiptHandler{decoded: decoded}
+}
+
+func (serverCodec) Name() string { return "proto" }
+
+func (serverCodec) Marshal(v interface{}) ([]byte, error) {
+	pm, ok := v.(proto.Message)
+	if !ok {
+		return nil, errNotProtoMessage
+	}
+	return proto.Marshal(pm)
+}
+
+func (sc serverCodec) Unmarshal(data []byte, v interface{}) error {
+	if sm, ok := v.(*signedMessage); ok {
+		return sm.unframe(data)
+	}
+	if err := unmarshal(data, v); err != nil {
+		return err
+	}
+	sc.decoded.Store(v, append([]byte(nil), data...))
+	return nil
+}
+
+// signedMessage is a message sent on a stream with its signature, framed
+// as the uvarint length of the signature, the signature and the message.
+type signedMessage struct {
+	msg       interface{}
+	body      []byte
+	signature string
+}
+
+func (sm *signedMessage) frame() []byte {
+	framed := binary.AppendUvarint(nil, uint64(len(sm.signature)))
+	framed = append(framed, sm.signature...)
+	return append(framed, sm.body...)
+}
+
+// unframe splits data into the signature and body of sm and decodes the
+// body into sm.msg. Unframed messages are kept whole and left undecoded,
+// without a signature, for verifying them to fail.
+func (sm *signedMessage) unframe(data []byte) error {
+	n, k := binary.Uvarint(data)
+	if k <= 0 || n > uint64(len(data)-k) {
+		sm.body = append([]byte(nil), data...)
+		return nil
+	}
+	sm.signature = string(data[k : k+int(n)])
+	sm.body = append([]byte(nil), data[k+int(n):]...)
+	return unmarshal(sm.body, sm.msg)
+}
+
+type receiptKey struct{}
+
+// receipt is the body of the last message received on a call.
+type receipt struct {
+	body []byte
+	ok   bool
+}
+
+// receiptHandler is told of each message right after serverCodec
+// decodes it, and moves its bytes to a receipt in the context.
+type receiptHandler struct {
+	decoded *sync.Map
+}
+
+func (receiptHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, receiptKey{}, new(receipt))
+}
+
+func (rh receiptHandler) HandleRPC(ctx context.Context, rs stats.RPCStats) {
+	in, ok := rs.(*stats.InPayload)
+	if !ok {
+		return
+	}
+	body, ok := rh.decoded.LoadAndDelete(in.Payload)
+	if !ok {
+		return
+	}
+	if rc, ok := ctx.Value(receiptKey{}).(*receipt); ok {
+		rc.body, rc.ok = body.([]byte), true
+	}
+}
+
+func (receiptHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (receiptHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+var errNoReceipt = errors.New("grpcauth: request bytes weren't kept, see ServerOptions")
+
+func receivedBody(ctx context.Context) ([]byte, error) {
+	rc, ok := ctx.Value(receiptKey{}).(*receipt)
+	if !ok || !rc.ok {
+		return nil, errNoReceipt
+	}
+	return rc.body, nil
+}
@@ -0,0 +1,351 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package grpcauth signs and verifies gRPC calls with authmid's schemes.
+//
+// Calls are signed like HTTP requests except that metadata stands in for
+// the headers, the full method name e.g. "/pkg.Service/Method" for the
+// method and path, and the request message as sent on the wire for the
+// body. Servers verify the bytes they received rather than a re-encoding
+// of the decoded message, so set them up with ServerOptions, and clients
+// with Signer.DialOptions.
+//
+// Streams are authenticated when they are opened, over their metadata and
+// method name, and then each message that the client sends is signed on
+// its own, chained to the signature of the opening and to its position in
+// the stream, so that messages can't be dropped, reordered, or moved to
+// another stream. Messages sent by the server aren't signed.
+package grpcauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/orijtech/authmid"
+)
+
+// ServerOptions returns the options for a server to verify its calls with
+// vf: the interceptors, and the codec and stats handler that keep the bytes
+// of each request for UnaryServerInterceptor.
+func ServerOptions(vf authmid.Authenticator) []grpc.ServerOption {
+	codec, handler := newServerCodec()
+	return []grpc.ServerOption{
+		grpc.ForceServerCodec(codec),
+		grpc.StatsHandler(handler),
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor(vf)),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor(vf)),
+	}
+}
+
+// UnaryServerInterceptor verifies unary calls with vf, passing the
+// authenticated Principal to handlers in their context. It fails calls
+// with codes.Internal on servers that aren't set up with ServerOptions.
+func UnaryServerInterceptor(vf authmid.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		body, err := receivedBody(ctx)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		ctx, err = authenticate(ctx, vf, info.FullMethod, body)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor verifies streams with vf as they're opened, and
+// each message that clients send on them as it's received. It fails
+// messages with codes.Internal on servers that aren't set up with
+// ServerOptions.
+func StreamServerInterceptor(vf authmid.Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), vf, info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		opening, err := vf.Signature(Message(info.FullMethod, md, nil).Header)
+		if err != nil {
+			return toStatus(err)
+		}
+		principal, _ := authmid.PrincipalFromContext(ctx)
+		secret, err := authmid.LookupSecretContext(ctx, vf, principal.APIKey)
+		if err != nil {
+			return toStatus(err)
+		}
+		return handler(srv, &authenticatedStream{
+			ServerStream: ss,
+			ctx:          ctx,
+			fullMethod:   info.FullMethod,
+			opening:      opening,
+			secret:       secret,
+			canon:        authmid.CanonicalizationOf(vf),
+		})
+	}
+}
+
+var errMessageSignature = errors.New("signature mismatch for a stream message")
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+
+	fullMethod string
+	opening    string
+	secret     []byte
+	canon      authmid.Canonicalization
+	seq        uint64
+}
+
+func (as *authenticatedStream) Context() context.Context {
+	return as.ctx
+}
+
+func (as *authenticatedStream) RecvMsg(m interface{}) error {
+	sm := &signedMessage{msg: m}
+	if err := as.ServerStream.RecvMsg(sm); err != nil {
+		return err
+	}
+	msg, values := streamMessage(as.fullMethod, as.opening, as.seq, sm.body)
+	want, err := authmid.SignMessage(as.secret, msg, values, as.canon)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	// Constant time so that timing doesn't reveal how much of a guess matched.
+	if !hmac.Equal([]byte(sm.signature), []byte(want)) {
+		return status.Error(codes.Unauthenticated, errMessageSignature.Error())
+	}
+	as.seq++
+	return nil
+}
+
+func authenticate(ctx context.Context, vf authmid.Authenticator, fullMethod string, body []byte) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	principal, err := authmid.Verify(ctx, vf, Message(fullMethod, md, body))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return authmid.ContextWithPrincipal(ctx, principal), nil
+}
+
+// Message returns the authmid.Message that stands in for a gRPC
+// call of fullMethod with md and body, when signing and verifying it.
+func Message(fullMethod string, md metadata.MD, body []byte) *authmid.Message {
+	hdr := make(http.Header, len(md))
+	for key, values := range md {
+		for _, value := range values {
+			hdr.Add(key, value)
+		}
+	}
+	return &authmid.Message{
+		// No method, so that only the full method name is signed.
+		Target: fullMethod,
+		Header: hdr,
+		Body:   bytes.NewReader(body),
+	}
+}
+
+// streamMessage returns the authmid.Message that stands in for the seq'th
+// message, counting from 0, that the client of a stream of fullMethod sends
+// when signing and verifying it, with the header values that chain it to
+// the signature of the stream's opening and to seq.
+func streamMessage(fullMethod, opening string, seq uint64, body []byte) (*authmid.Message, []string) {
+	msg := &authmid.Message{
+		Target: fullMethod,
+		Header: make(http.Header),
+		Body:   bytes.NewReader(body),
+	}
+	return msg, []string{opening, strconv.FormatUint(seq, 10)}
+}
+
+func toStatus(err error) error {
+	code := codes.Unauthenticated
+	switch authmid.ErrorStatus(err) {
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusInternalServerError:
+		code = codes.Internal
+	}
+	return status.Error(code, authmid.ErrorMessage(err))
+}
+
+// Signer signs outgoing calls with Scheme, which should match the
+// Authenticator of the servers. If TimestampHeader is set, it is set
+// to the current Unix time before signing, it should be among
+// Scheme.Headers for servers to check it.
+type Signer struct {
+	Scheme *authmid.HeaderAuthenticator
+
+	APIKey string
+	Secret []byte
+
+	TimestampHeader string
+}
+
+// Sign returns md with the signature of a call of fullMethod with body.
+func (s *Signer) Sign(fullMethod string, md metadata.MD, body []byte) (metadata.MD, error) {
+	msg, err := s.sign(fullMethod, md, body)
+	if err != nil {
+		return nil, err
+	}
+	return metadataOf(msg), nil
+}
+
+func metadataOf(msg *authmid.Message) metadata.MD {
+	md := make(metadata.MD, len(msg.Header))
+	for key, values := range msg.Header {
+		md[strings.ToLower(key)] = values
+	}
+	return md
+}
+
+func (s *Signer) sign(fullMethod string, md metadata.MD, body []byte) (*authmid.Message, error) {
+	msg := Message(fullMethod, md, body)
+	if s.TimestampHeader != "" {
+		msg.Header.Set(s.TimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	}
+	if err := s.Scheme.SignMessage(msg, s.APIKey, s.Secret); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// DialOptions returns the options for a client to sign its calls: the
+// interceptors, and the codec that sends messages as they were signed.
+func (s *Signer) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(clientCodec{})),
+		grpc.WithChainUnaryInterceptor(s.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(s.StreamClientInterceptor()),
+	}
+}
+
+func (s *Signer) signContext(ctx context.Context, fullMethod string, body []byte) (context.Context, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	signed, err := s.Sign(fullMethod, md, body)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.NewOutgoingContext(ctx, signed), nil
+}
+
+// UnaryClientInterceptor signs unary calls. It signs the deterministic
+// encoding of requests, which is only what's sent with DialOptions' codec.
+func (s *Signer) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		body, err := marshal(req)
+		if err != nil {
+			return err
+		}
+		if ctx, err = s.signContext(ctx, method, body); err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor signs streams as they're opened, and each message
+// sent on them, which is only sent as it was signed with DialOptions' codec.
+func (s *Signer) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		msg, err := s.sign(method, md, nil)
+		if err != nil {
+			return nil, err
+		}
+		opening, err := s.Scheme.Signature(msg.Header)
+		if err != nil {
+			return nil, err
+		}
+		cs, err := streamer(metadata.NewOutgoingContext(ctx, metadataOf(msg)), desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &signingStream{ClientStream: cs, signer: s, fullMethod: method, opening: opening}, nil
+	}
+}
+
+type signingStream struct {
+	grpc.ClientStream
+	signer *Signer
+
+	fullMethod string
+	opening    string
+	seq        uint64
+}
+
+func (ss *signingStream) SendMsg(m interface{}) error {
+	body, err := marshal(m)
+	if err != nil {
+		return err
+	}
+	msg, values := streamMessage(ss.fullMethod, ss.opening, ss.seq, body)
+	signature, err := authmid.SignMessage(ss.signer.Secret, msg, values, authmid.CanonicalizationOf(ss.signer.Scheme))
+	if err != nil {
+		return err
+	}
+	ss.seq++
+	return ss.ClientStream.SendMsg(&signedMessage{body: body, signature: signature})
+}
+
+// StreamCredentials returns credentials for streaming calls, e.g. via
+// grpc.WithPerRPCCredentials. Unlike the client interceptors they can't
+// sign unary calls or the messages of streams, which per-RPC credentials
+// aren't given, so servers only accept streams of theirs that the client
+// sends no messages on.
+func (s *Signer) StreamCredentials(requireTransportSecurity bool) credentials.PerRPCCredentials {
+	return &streamCredentials{signer: s, secure: requireTransportSecurity}
+}
+
+type streamCredentials struct {
+	signer *Signer
+	secure bool
+}
+
+func (sc *streamCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	ri, ok := credentials.RequestInfoFromContext(ctx)
+	if !ok {
+		return nil, errNoRequestInfo
+	}
+	signed, err := sc.signer.Sign(ri.Method, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	kvs := make(map[string]string, len(signed))
+	for key, values := range signed {
+		kvs[key] = values[0]
+	}
+	return kvs, nil
+}
+
+func (sc *streamCredentials) RequireTransportSecurity() bool {
+	return sc.secure
+}
+
+var errNoRequestInfo = errors.New("no request info in the context")
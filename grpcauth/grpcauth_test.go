@@ -0,0 +1,375 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcauth_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	testgrpc "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+	"github.com/orijtech/authmid/grpcauth"
+)
+
+func newScheme() *authmid.HeaderAuthenticator {
+	backend, _ := memory.NewWithMap(map[string]string{"svc-a": "secret-a"})
+	return &authmid.HeaderAuthenticator{
+		Backend:         backend,
+		KeyHeader:       "Authmid-Key",
+		SignatureHeader: "Authmid-Signature",
+		Headers:         []authmid.HeaderSpec{{Name: "Authmid-Timestamp"}},
+	}
+}
+
+// principalHealth records the principal of each call.
+type principalHealth struct {
+	*health.Server
+	principals chan *authmid.Principal
+}
+
+func (ph *principalHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	p, _ := authmid.PrincipalFromContext(ctx)
+	ph.principals <- p
+	return ph.Server.Check(ctx, req)
+}
+
+func dial(t *testing.T, signer *grpcauth.Signer) (healthpb.HealthClient, *principalHealth, func()) {
+	var opts []grpc.DialOption
+	if signer != nil {
+		opts = signer.DialOptions()
+	}
+	return dialWith(t, opts...)
+}
+
+func dialWith(t *testing.T, clientOpts ...grpc.DialOption) (healthpb.HealthClient, *principalHealth, func()) {
+	cc, ph, closeFn := serve(t, clientOpts...)
+	return healthpb.NewHealthClient(cc), ph, closeFn
+}
+
+// echoService echoes the payloads of full duplex calls.
+type echoService struct {
+	testgrpc.UnimplementedTestServiceServer
+}
+
+func (echoService) FullDuplexCall(stream testgrpc.TestService_FullDuplexCallServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&testgrpc.StreamingOutputCallResponse{Payload: req.Payload}); err != nil {
+			return err
+		}
+	}
+}
+
+func serve(t *testing.T, clientOpts ...grpc.DialOption) (*grpc.ClientConn, *principalHealth, func()) {
+	lis := bufconn.Listen(1 << 16)
+	srv := grpc.NewServer(grpcauth.ServerOptions(newScheme())...)
+	ph := &principalHealth{Server: health.NewServer(), principals: make(chan *authmid.Principal, 1)}
+	healthpb.RegisterHealthServer(srv, ph)
+	testgrpc.RegisterTestServiceServer(srv, echoService{})
+	go srv.Serve(lis)
+
+	opts := []grpc.DialOption{
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+	cc, err := grpc.NewClient("passthrough:///bufnet", append(opts, clientOpts...)...)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return cc, ph, func() {
+		cc.Close()
+		srv.Stop()
+	}
+}
+
+func TestUnary(t *testing.T) {
+	tests := [...]struct {
+		signer   *grpcauth.Signer
+		wantCode codes.Code
+	}{
+		0: {signer: &grpcauth.Signer{APIKey: "svc-a", Secret: []byte("secret-a")}, wantCode: codes.OK},
+		1: {signer: &grpcauth.Signer{APIKey: "svc-a", Secret: []byte("secret-b")}, wantCode: codes.Unauthenticated},
+		2: {signer: &grpcauth.Signer{APIKey: "svc-b", Secret: []byte("secret-a")}, wantCode: codes.Unauthenticated},
+		3: {wantCode: codes.Unauthenticated},
+	}
+
+	for i, tt := range tests {
+		if tt.signer != nil {
+			tt.signer.Scheme = newScheme()
+			tt.signer.TimestampHeader = "Authmid-Timestamp"
+		}
+		client, ph, closeFn := dial(t, tt.signer)
+		_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		closeFn()
+		if got := status.Code(err); got != tt.wantCode {
+			t.Errorf("#%d: got %v want %v; err: %v", i, got, tt.wantCode, err)
+			continue
+		}
+		if tt.wantCode != codes.OK {
+			continue
+		}
+		if p := <-ph.principals; p == nil || p.APIKey != "svc-a" {
+			t.Errorf("#%d: got principal %+v", i, p)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	for i, secret := range []string{"secret-a", "secret-b"} {
+		signer := &grpcauth.Signer{
+			Scheme:          newScheme(),
+			APIKey:          "svc-a",
+			Secret:          []byte(secret),
+			TimestampHeader: "Authmid-Timestamp",
+		}
+		client, _, closeFn := dial(t, signer)
+		stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		closeFn()
+		wantCode := codes.OK
+		if i == 1 {
+			wantCode = codes.Unauthenticated
+		}
+		if got := status.Code(err); got != wantCode {
+			t.Errorf("#%d: got %v want %v; err: %v", i, got, wantCode, err)
+		}
+	}
+}
+
+func TestStreamMessages(t *testing.T) {
+	signer := &grpcauth.Signer{
+		Scheme:          newScheme(),
+		APIKey:          "svc-a",
+		Secret:          []byte("secret-a"),
+		TimestampHeader: "Authmid-Timestamp",
+	}
+	tests := [...]struct {
+		opts     []grpc.DialOption
+		wantCode codes.Code
+	}{
+		0: {opts: signer.DialOptions(), wantCode: codes.OK},
+
+		// The opening is signed but the messages aren't.
+		1: {opts: []grpc.DialOption{grpc.WithPerRPCCredentials(signer.StreamCredentials(false))}, wantCode: codes.Unauthenticated},
+
+		// The first signed message is sent again in place of the second.
+		2: {opts: append(signer.DialOptions(), grpc.WithChainStreamInterceptor(replayFirst)), wantCode: codes.Unauthenticated},
+	}
+
+	for i, tt := range tests {
+		cc, _, closeFn := serve(t, tt.opts...)
+		err := echo(testgrpc.NewTestServiceClient(cc), "first", "second", "third")
+		closeFn()
+		if got := status.Code(err); got != tt.wantCode {
+			t.Errorf("#%d: got %v want %v; err: %v", i, got, tt.wantCode, err)
+		}
+	}
+}
+
+func replayFirst(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &replayingStream{ClientStream: cs}, nil
+}
+
+// replayingStream sends the first message it's given in place of all others.
+type replayingStream struct {
+	grpc.ClientStream
+	first interface{}
+}
+
+func (rs *replayingStream) SendMsg(m interface{}) error {
+	if rs.first == nil {
+		rs.first = m
+	}
+	return rs.ClientStream.SendMsg(rs.first)
+}
+
+// echo sends each of bodies on a full duplex call, checking their echoes.
+func echo(client testgrpc.TestServiceClient, bodies ...string) error {
+	stream, err := client.FullDuplexCall(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, body := range bodies {
+		req := &testgrpc.StreamingOutputCallRequest{Payload: &testgrpc.Payload{Body: []byte(body)}}
+		if err := stream.Send(req); err != nil {
+			return err
+		}
+		res, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if got := string(res.GetPayload().GetBody()); got != body {
+			return fmt.Errorf("got echo %q want %q", got, body)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// repeatedCodec sends the service field of health checks twice, first as
+// "ignored", which decodes like the deterministic encoding but isn't it.
+type repeatedCodec struct{}
+
+func (repeatedCodec) Name() string { return "proto" }
+
+func (repeatedCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := proto.Marshal(v.(proto.Message))
+	if err != nil {
+		return nil, err
+	}
+	prefix := append([]byte{0x0a, byte(len("ignored"))}, "ignored"...)
+	return append(prefix, b...), nil
+}
+
+func (repeatedCodec) Unmarshal(data []byte, v interface{}) error {
+	return proto.Unmarshal(data, v.(proto.Message))
+}
+
+func TestUnaryVerifiesWireBytes(t *testing.T) {
+	signer := &grpcauth.Signer{
+		Scheme:          newScheme(),
+		APIKey:          "svc-a",
+		Secret:          []byte("secret-a"),
+		TimestampHeader: "Authmid-Timestamp",
+	}
+	sign := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		body, err := repeatedCodec{}.Marshal(req)
+		if err != nil {
+			return err
+		}
+		md, err := signer.Sign(method, nil, body)
+		if err != nil {
+			return err
+		}
+		return invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+	}
+	client, ph, closeFn := dialWith(t,
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(repeatedCodec{})),
+		grpc.WithUnaryInterceptor(sign),
+	)
+	defer closeFn()
+	ph.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "svc"})
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if p := <-ph.principals; p == nil || p.APIKey != "svc-a" {
+		t.Errorf("got principal %+v", p)
+	}
+}
+
+func TestServerWithoutCodec(t *testing.T) {
+	lis := bufconn.Listen(1 << 16)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(grpcauth.UnaryServerInterceptor(newScheme())))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	signer := &grpcauth.Signer{
+		Scheme:          newScheme(),
+		APIKey:          "svc-a",
+		Secret:          []byte("secret-a"),
+		TimestampHeader: "Authmid-Timestamp",
+	}
+	opts := append(signer.DialOptions(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	cc, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer cc.Close()
+
+	_, err = healthpb.NewHealthClient(cc).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if got := status.Code(err); got != codes.Internal {
+		t.Errorf("got %v want %v; err: %v", got, codes.Internal, err)
+	}
+}
+
+// brokenBackend fails like a backend whose database is down.
+type brokenBackend struct {
+	*authmid.HeaderAuthenticator
+}
+
+func (brokenBackend) LookupSecretContext(context.Context, string) ([]byte, error) {
+	return nil, &authmid.InternalError{Err: errors.New("dial tcp 10.0.0.7:5432: connection refused")}
+}
+
+func TestInternalError(t *testing.T) {
+	lis := bufconn.Listen(1 << 16)
+	srv := grpc.NewServer(grpcauth.ServerOptions(brokenBackend{newScheme()})...)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	signer := &grpcauth.Signer{
+		Scheme:          newScheme(),
+		APIKey:          "svc-a",
+		Secret:          []byte("secret-a"),
+		TimestampHeader: "Authmid-Timestamp",
+	}
+	opts := append(signer.DialOptions(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	cc, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer cc.Close()
+
+	_, err = healthpb.NewHealthClient(cc).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if got := status.Code(err); got != codes.Internal {
+		t.Errorf("got %v want %v; err: %v", got, codes.Internal, err)
+	}
+	if strings.Contains(status.Convert(err).Message(), "10.0.0.7") {
+		t.Errorf("the backend's error was leaked: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type HeaderSpec struct {
+	Name string
+
+	// Optional headers are skipped with a warning when absent,
+	// otherwise their absence fails the request.
+	Optional bool
+}
+
+// HeaderAuthenticator is an Authenticator that reads the API key and
+// signature from fixed headers and signs the values of Headers, in order.
+type HeaderAuthenticator struct {
+	Backend ReadOnlyBackend
+
+	KeyHeader       string
+	SignatureHeader string
+	Headers         []HeaderSpec
+
+	// Canonical is reported to Checker, see Canonicalizer.
+	Canonical Canonicalization
+}
+
+var (
+	_ Authenticator  = (*HeaderAuthenticator)(nil)
+	_ Canonicalizer  = (*HeaderAuthenticator)(nil)
+	_ ScopeBackend   = (*HeaderAuthenticator)(nil)
+	_ ContextBackend = (*HeaderAuthenticator)(nil)
+)
+
+var errNilBackend = errors.New("expecting a non-nil backend")
+
+func (ha *HeaderAuthenticator) HeaderValues(hdr http.Header) (values, warnings []string, err error) {
+	var errsList []string
+	for _, spec := range ha.Headers {
+		value, err := headerValueOrErr(hdr, spec.Name)
+		if err == nil {
+			values = append(values, value)
+			continue
+		}
+		if spec.Optional {
+			warnings = append(warnings, err.Error())
+		} else {
+			errsList = append(errsList, err.Error())
+		}
+	}
+	if len(errsList) > 0 {
+		return nil, warnings, errors.New(strings.Join(errsList, "\n"))
+	}
+	return values, warnings, nil
+}
+
+func (ha *HeaderAuthenticator) LookupAPIKey(hdr http.Header) (string, error) {
+	return headerValueOrErr(hdr, ha.KeyHeader)
+}
+
+func (ha *HeaderAuthenticator) Signature(hdr http.Header) (string, error) {
+	return headerValueOrErr(hdr, ha.SignatureHeader)
+}
+
+func (ha *HeaderAuthenticator) LookupSecret(apiKey string) ([]byte, error) {
+	if ha.Backend == nil {
+		return nil, errNilBackend
+	}
+	return ha.Backend.LookupSecret(apiKey)
+}
+
+func (ha *HeaderAuthenticator) LookupSecretContext(ctx context.Context, apiKey string) ([]byte, error) {
+	if ha.Backend == nil {
+		return nil, errNilBackend
+	}
+	return LookupSecretContext(ctx, ha.Backend, apiKey)
+}
+
+// LookupScopes delegates to Backend if it implements ScopeBackend.
+func (ha *HeaderAuthenticator) LookupScopes(apiKey string) ([]string, error) {
+	if sb, ok := ha.Backend.(ScopeBackend); ok {
+		return sb.LookupScopes(apiKey)
+	}
+	return nil, nil
+}
+
+func (ha *HeaderAuthenticator) Canonicalization() Canonicalization {
+	return ha.Canonical
+}
+
+// CredentialHeaders returns the names of the key and signature headers,
+// which services behind a proxy should be sent without.
+func (ha *HeaderAuthenticator) CredentialHeaders() []string {
+	return []string{ha.KeyHeader, ha.SignatureHeader}
+}
+
+// SignRequest sets the key and signature headers of req so that it passes
+// Checker with ha. The other headers in ha.Headers must already be set.
+func (ha *HeaderAuthenticator) SignRequest(req *http.Request, apiKey string, secret []byte) error {
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.Header.Set(ha.KeyHeader, apiKey)
+	values, _, err := ha.HeaderValues(req.Header)
+	if err != nil {
+		return err
+	}
+	signature, err := Sign(secret, req, values, ha.Canonical)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(ha.SignatureHeader, signature)
+	return nil
+}
+
+func headerValueOrErr(hdr http.Header, key string) (string, error) {
+	if value := hdr.Get(key); value != "" {
+		return value, nil
+	}
+	return "", fmt.Errorf("missing %q header", key)
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid_test
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+)
+
+func newHeaderAuthenticator() *authmid.HeaderAuthenticator {
+	backend, _ := memory.NewWithMap(map[string]string{apiKey1: string(bAPISecret1)})
+	return &authmid.HeaderAuthenticator{
+		Backend:         backend,
+		KeyHeader:       "TEST-ACCESS-KEY",
+		SignatureHeader: "TEST-ACCESS-SIGN",
+		Headers: []authmid.HeaderSpec{
+			{Name: "TEST-ACCESS-TIMESTAMP"},
+			{Name: "TEST-VERSION", Optional: true},
+		},
+	}
+}
+
+func TestHeaderAuthenticatorHeaderValues(t *testing.T) {
+	ha := newHeaderAuthenticator()
+	tests := [...]struct {
+		hdr          http.Header
+		want         []string
+		wantWarnings int
+		wantErr      bool
+	}{
+		0: {
+			hdr:  http.Header{"Test-Access-Timestamp": {"1500000000"}, "Test-Version": {"2017-06-07"}},
+			want: []string{"1500000000", "2017-06-07"},
+		},
+		1: {
+			hdr:          http.Header{"Test-Access-Timestamp": {"1500000000"}},
+			want:         []string{"1500000000"},
+			wantWarnings: 1,
+		},
+		2: {
+			hdr:     http.Header{"Test-Version": {"2017-06-07"}},
+			wantErr: true,
+		},
+	}
+
+	for i, tt := range tests {
+		values, warnings, err := ha.HeaderValues(tt.hdr)
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("#%d: gotErr=%v wantErr=%v; err:(%v)", i, gotErr, tt.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(values, tt.want) {
+			t.Errorf("#%d: got %q want %q", i, values, tt.want)
+		}
+		if len(warnings) != tt.wantWarnings {
+			t.Errorf("#%d: got %d warnings want %d", i, len(warnings), tt.wantWarnings)
+		}
+	}
+}
+
+func TestHeaderAuthenticatorRoundTrip(t *testing.T) {
+	ha := newHeaderAuthenticator()
+	ha.Canonical.Query = authmid.RawQuery
+	check := authmid.Checker(ha)
+
+	tests := [...]struct {
+		apiKey  string
+		secret  []byte
+		wantErr bool
+	}{
+		0: {apiKey: apiKey1, secret: bAPISecret1},
+		1: {apiKey: apiKey1, secret: bAPISecret2, wantErr: true},
+		2: {apiKey: apiKey2, secret: bAPISecret2, wantErr: true}, // Not in the backend
+	}
+
+	for i, tt := range tests {
+		req, _ := http.NewRequest("PUT", "https://orijtech.com/v1/items?z=1&a=2", bytes.NewReader([]byte(`{"id": 1}`)))
+		req.Header.Set("TEST-ACCESS-TIMESTAMP", "1500000000")
+		if err := ha.SignRequest(req, tt.apiKey, tt.secret); err != nil {
+			t.Fatalf("#%d: SignRequest: %v", i, err)
+		}
+		err := check(req)
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("#%d: gotErr=%v wantErr=%v; err:(%v)", i, gotErr, tt.wantErr, err)
+		}
+	}
+
+	if err := authmid.Checker(&authmid.HeaderAuthenticator{KeyHeader: "K", SignatureHeader: "S"})(&http.Request{
+		Header: http.Header{"K": {"k"}, "S": {"s"}},
+	}); err == nil {
+		t.Errorf("expected an error without a backend")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// LookupObserver is notified of every LookupSecret of the
+// backends wrapped with InstrumentBackend.
+type LookupObserver interface {
+	ObserveLookup(backend string, latency time.Duration, result LookupResult)
+}
+
+type LookupResult string
+
+const (
+	// LookupHit and LookupMiss, over a chain's first backend, give its cache hit ratio.
+	LookupHit   LookupResult = "hit"
+	LookupMiss  LookupResult = "miss"
+	LookupError LookupResult = "error"
+)
+
+// InstrumentBackend reports the LookupSecret calls of b to o as those of
+// the backend named name. Listing, scopes and limits are passed through to
+// b, writes and listing fail with errors.ErrUnsupported if b doesn't
+// support them.
+func InstrumentBackend(name string, b Backend, o LookupObserver) Backend {
+	return &instrumentedBackend{Backend: b, name: name, observer: o}
+}
+
+type instrumentedBackend struct {
+	Backend
+	name     string
+	observer LookupObserver
+}
+
+var (
+	_ Lister         = (*instrumentedBackend)(nil)
+	_ ScopeBackend   = (*instrumentedBackend)(nil)
+	_ ScopeWriter    = (*instrumentedBackend)(nil)
+	_ LimitBackend   = (*instrumentedBackend)(nil)
+	_ LimitWriter    = (*instrumentedBackend)(nil)
+	_ ContextBackend = (*instrumentedBackend)(nil)
+)
+
+func (ib *instrumentedBackend) LookupSecret(apiKey string) ([]byte, error) {
+	return ib.LookupSecretContext(context.Background(), apiKey)
+}
+
+func (ib *instrumentedBackend) LookupSecretContext(ctx context.Context, apiKey string) ([]byte, error) {
+	start := time.Now()
+	secret, err := LookupSecretContext(ctx, ib.Backend, apiKey)
+	result := LookupHit
+	switch {
+	case errors.Is(err, ErrNoSuchAPIKey):
+		result = LookupMiss
+	case err != nil:
+		result = LookupError
+	}
+	ib.observer.ObserveLookup(ib.name, time.Since(start), result)
+	return secret, err
+}
+
+func (ib *instrumentedBackend) LookupScopes(apiKey string) ([]string, error) {
+	if sb, ok := ib.Backend.(ScopeBackend); ok {
+		return sb.LookupScopes(apiKey)
+	}
+	return nil, nil
+}
+
+func (ib *instrumentedBackend) LookupLimit(apiKey string) (*Limit, error) {
+	if lb, ok := ib.Backend.(LimitBackend); ok {
+		return lb.LookupLimit(apiKey)
+	}
+	return nil, nil
+}
+
+func (ib *instrumentedBackend) ListAPIKeys(cursor string, limit int) ([]string, string, error) {
+	if l, ok := ib.Backend.(Lister); ok {
+		return l.ListAPIKeys(cursor, limit)
+	}
+	return nil, "", errors.ErrUnsupported
+}
+
+func (ib *instrumentedBackend) SetScopes(apiKey string, scopes []string) error {
+	if sw, ok := ib.Backend.(ScopeWriter); ok {
+		return sw.SetScopes(apiKey, scopes)
+	}
+	return errors.ErrUnsupported
+}
+
+func (ib *instrumentedBackend) SetLimit(apiKey string, limit *Limit) error {
+	if lw, ok := ib.Backend.(LimitWriter); ok {
+		return lw.SetLimit(apiKey, limit)
+	}
+	return errors.ErrUnsupported
+}
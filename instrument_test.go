@@ -0,0 +1,83 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+)
+
+type nopObserver struct{}
+
+func (nopObserver) ObserveLookup(string, time.Duration, authmid.LookupResult) {}
+
+func TestInstrumentBackendForwards(t *testing.T) {
+	mem, _ := memory.NewWithMap(map[string]string{apiKey1: string(bAPISecret1)})
+	backend := authmid.InstrumentBackend("memory", mem, nopObserver{})
+
+	if err := backend.(authmid.ScopeWriter).SetScopes(apiKey1, []string{"orders:read"}); err != nil {
+		t.Fatalf("SetScopes: %v", err)
+	}
+	if err := backend.(authmid.LimitWriter).SetLimit(apiKey1, &authmid.Limit{Rate: 1, Burst: 1}); err != nil {
+		t.Fatalf("SetLimit: %v", err)
+	}
+	if limit, err := mem.LookupLimit(apiKey1); err != nil || limit == nil {
+		t.Errorf("the limit wasn't set on the wrapped backend: (%v, %v)", limit, err)
+	}
+	keys, _, err := backend.(authmid.Lister).ListAPIKeys("", 10)
+	if err != nil || len(keys) != 1 || keys[0] != apiKey1 {
+		t.Errorf("ListAPIKeys: got (%v, %v)", keys, err)
+	}
+
+	ha := newHeaderAuthenticator()
+	ha.Backend = backend
+	handler := authmid.Middleware(ha, authmid.Require("orders:read")(http.NotFoundHandler()))
+	req := httptest.NewRequest("GET", "https://orijtech.com/", nil)
+	req.Header.Set("TEST-ACCESS-TIMESTAMP", "1500000000")
+	if err := ha.SignRequest(req, apiKey1, bAPISecret1); err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got %d, expecting the scopes to pass Require", rec.Code)
+	}
+
+	bare := authmid.InstrumentBackend("bare", bareBackend{mem}, nopObserver{})
+	if _, _, err := bare.(authmid.Lister).ListAPIKeys("", 10); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("ListAPIKeys: got %v want %v", err, errors.ErrUnsupported)
+	}
+	if err := bare.(authmid.ScopeWriter).SetScopes(apiKey1, nil); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("SetScopes: got %v want %v", err, errors.ErrUnsupported)
+	}
+}
+
+// bareBackend hides the optional interfaces of its Backend.
+type bareBackend struct {
+	b authmid.Backend
+}
+
+func (bb bareBackend) LookupSecret(apiKey string) ([]byte, error) { return bb.b.LookupSecret(apiKey) }
+func (bb bareBackend) UpsertSecret(apiKey, secret string) error {
+	return bb.b.UpsertSecret(apiKey, secret)
+}
+func (bb bareBackend) DeleteAPIKey(apiKey string) error { return bb.b.DeleteAPIKey(apiKey) }
+func (bb bareBackend) Close() error                     { return bb.b.Close() }
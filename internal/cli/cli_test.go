@@ -0,0 +1,117 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/internal/cli"
+)
+
+func TestOpenBackendSpecs(t *testing.T) {
+	for _, spec := range []string{"", "keys.json", "ftp://keys.json"} {
+		if _, err := cli.OpenBackend(spec, "keys"); err == nil {
+			t.Errorf("%q: expecting an error", spec)
+		}
+	}
+}
+
+func TestFileBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := ioutil.WriteFile(path, []byte(`{"k1": "s1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading leaves the file as it was.
+	backend, err := cli.OpenBackend("file://"+path, "")
+	if err != nil {
+		t.Fatalf("OpenBackend: %v", err)
+	}
+	if secret, err := backend.LookupSecret("k1"); err != nil || string(secret) != "s1" {
+		t.Errorf("got (%q, %v) want (%q, nil)", secret, err, "s1")
+	}
+	if err := backend.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if blob, _ := ioutil.ReadFile(path); string(blob) != `{"k1": "s1"}` {
+		t.Errorf("a read rewrote the file: %s", blob)
+	}
+
+	// Writes are saved on Close.
+	backend, _ = cli.OpenBackend("file://"+path, "")
+	backend.UpsertSecret("k2", "s2")
+	if err := backend.DeleteAPIKey("k1"); err != nil {
+		t.Errorf("DeleteAPIKey: %v", err)
+	}
+	if err := backend.DeleteAPIKey("k1"); err != authmid.ErrNoSuchAPIKey {
+		t.Errorf("deleting twice: got %v want %v", err, authmid.ErrNoSuchAPIKey)
+	}
+	if err := backend.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	backend, _ = cli.OpenBackend("file://"+path, "")
+	defer backend.Close()
+	if _, err := backend.LookupSecret("k1"); err != authmid.ErrNoSuchAPIKey {
+		t.Errorf("k1: got %v want %v", err, authmid.ErrNoSuchAPIKey)
+	}
+	if secret, err := backend.LookupSecret("k2"); err != nil || string(secret) != "s2" {
+		t.Errorf("k2: got (%q, %v) want (%q, nil)", secret, err, "s2")
+	}
+
+	// Absent files are created on the first write.
+	absent := filepath.Join(t.TempDir(), "new.json")
+	backend, _ = cli.OpenBackend("file://"+absent, "")
+	backend.Close()
+	if _, err := os.Stat(absent); !os.IsNotExist(err) {
+		t.Errorf("got %v, expecting no file without writes", err)
+	}
+}
+
+func TestSchemeFlags(t *testing.T) {
+	var sf cli.SchemeFlags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	sf.Register(fs)
+	err := fs.Parse([]string{"-key-header", "K", "-signature-header", "S", "-signed-header", "T", "-escaped-path", "-bind-header", "host"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	ha := sf.Authenticator(nil)
+	if ha.KeyHeader != "K" || ha.SignatureHeader != "S" || len(ha.Headers) != 1 || ha.Headers[0].Name != "T" {
+		t.Errorf("got %+v", ha)
+	}
+
+	// Signing and verifying with the flags agree.
+	backend, _ := cli.OpenBackend("file://"+filepath.Join(t.TempDir(), "keys.json"), "")
+	defer backend.Close()
+	backend.UpsertSecret("k1", "s1")
+	req := httptest.NewRequest("GET", "/a%2Fb", nil)
+	req.Header.Set("T", "1500000000")
+	if err := ha.SignRequest(req, "k1", []byte("s1")); err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+	if err := authmid.Checker(sf.Authenticator(backend))(req); err != nil {
+		t.Errorf("Checker: %v", err)
+	}
+	req.Host = "elsewhere.example.com"
+	if err := authmid.Checker(sf.Authenticator(backend))(req); err == nil {
+		t.Errorf("expecting the host to be bound")
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/orijtech/authmid"
+)
+
+// StringsFlag collects the values of a repeated flag.
+type StringsFlag []string
+
+func (sf *StringsFlag) String() string     { return strings.Join(*sf, ",") }
+func (sf *StringsFlag) Set(v string) error { *sf = append(*sf, v); return nil }
+
+// SchemeFlags are the flags that describe how requests are signed,
+// they must match the verifying server's Authenticator.
+type SchemeFlags struct {
+	keyHeader            string
+	signatureHeader      string
+	signedHeaders        StringsFlag
+	excludeMethodAndPath bool
+	escapedPath          bool
+	rawQuery             bool
+	boundHeaders         StringsFlag
+}
+
+func (sf *SchemeFlags) Canonicalization() authmid.Canonicalization {
+	c := authmid.Canonicalization{ExcludeMethodAndPath: sf.excludeMethodAndPath}
+	if sf.escapedPath {
+		c.Path = authmid.EscapedPath
+	}
+	if sf.rawQuery {
+		c.Query = authmid.RawQuery
+	}
+	if len(sf.boundHeaders) > 0 {
+		c.Headers = &authmid.SignedHeaders{Names: sf.boundHeaders}
+	}
+	return c
+}
+
+func (sf *SchemeFlags) Authenticator(backend authmid.ReadOnlyBackend) *authmid.HeaderAuthenticator {
+	ha := &authmid.HeaderAuthenticator{
+		Backend:         backend,
+		KeyHeader:       sf.keyHeader,
+		SignatureHeader: sf.signatureHeader,
+		Canonical:       sf.Canonicalization(),
+	}
+	for _, name := range sf.signedHeaders {
+		ha.Headers = append(ha.Headers, authmid.HeaderSpec{Name: name})
+	}
+	return ha
+}
+
+func (sf *SchemeFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&sf.keyHeader, "key-header", "X-Authmid-Key", "the header that carries the API key")
+	fs.StringVar(&sf.signatureHeader, "signature-header", "X-Authmid-Signature", "the header that carries the signature")
+	fs.Var(&sf.signedHeaders, "signed-header", "a header whose value is signed, in order; repeatable")
+	fs.BoolVar(&sf.excludeMethodAndPath, "exclude-method-path", false, "only sign the header values and body")
+	fs.BoolVar(&sf.escapedPath, "escaped-path", false, "sign the path with its percent-encodings as sent")
+	fs.BoolVar(&sf.rawQuery, "raw-query", false, "sign the query as sent instead of sorted and re-encoded")
+	fs.Var(&sf.boundHeaders, "bind-header", `a header bound by name e.g. "host" or "content-type"; repeatable`)
+}
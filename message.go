@@ -0,0 +1,134 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Message is anything signed like an HTTP request: a gRPC call, a queued
+// event, a WebSocket handshake or a stored event. It's canonicalized as a
+// request of Method for Target, which is a path with an optional query,
+// e.g. "/pkg.Service/Method" or "/topics/orders", or even blank. Targets
+// that aren't blank must start with "/", since they're taken as the path
+// and query verbatim, never as a URL with a scheme or host.
+// Headers stand in for whatever metadata the transport carries.
+//
+// Host and Scheme, if the transport knows them, are what SignedHeaders'
+// "host" and ":scheme" sign, as a request's Host and URL.Scheme are.
+type Message struct {
+	Method string
+	Target string
+	Header http.Header
+	Body   io.Reader
+
+	Host   string
+	Scheme string
+}
+
+var (
+	errNilMessage    = errors.New("expecting a non-nil message")
+	errInvalidTarget = errors.New(`expecting a target that starts with "/"`)
+)
+
+// Verify authenticates msg with vf by Checker's rules.
+// It reads msg.Body and then restores it.
+func Verify(ctx context.Context, vf Authenticator, msg *Message) (*Principal, error) {
+	req, err := msg.request(ctx)
+	if err != nil {
+		return nil, err
+	}
+	principal, err := Authenticate(vf, req)
+	msg.Body = req.Body
+	return principal, err
+}
+
+// SignMessage returns the signature that Verify expects for msg, see Sign.
+func SignMessage(secret []byte, msg *Message, headerValues []string, c Canonicalization) (string, error) {
+	req, err := msg.request(context.Background())
+	if err != nil {
+		return "", err
+	}
+	signature, err := Sign(secret, req, headerValues, c)
+	msg.Body = req.Body
+	return signature, err
+}
+
+// SignMessage is SignRequest for messages, it sets the key and signature
+// headers of msg so that it passes Verify with ha.
+func (ha *HeaderAuthenticator) SignMessage(msg *Message, apiKey string, secret []byte) error {
+	req, err := msg.request(context.Background())
+	if err != nil {
+		return err
+	}
+	err = ha.SignRequest(req, apiKey, secret)
+	msg.Header, msg.Body = req.Header, req.Body
+	return err
+}
+
+// request is the *http.Request that msg is canonicalized as.
+func (msg *Message) request(ctx context.Context) (*http.Request, error) {
+	if msg == nil {
+		return nil, errNilMessage
+	}
+	u, err := targetURL(msg.Target)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = msg.Scheme
+	hdr := msg.Header
+	if hdr == nil {
+		hdr = make(http.Header)
+	}
+	req := &http.Request{
+		Method: msg.Method,
+		URL:    u,
+		Host:   msg.Host,
+		Header: hdr,
+		Body:   ioutil.NopCloser(bytes.NewReader(nil)),
+	}
+	if msg.Body != nil {
+		req.Body = ioutil.NopCloser(msg.Body)
+	}
+	return req.WithContext(ctx), nil
+}
+
+// targetURL splits target into a path and query. It doesn't use url.Parse,
+// which would take "//host/path" for a host and "topic:event" for a scheme,
+// dropping them from the path that is signed.
+func targetURL(target string) (*url.URL, error) {
+	if target == "" {
+		return &url.URL{}, nil
+	}
+	if !strings.HasPrefix(target, "/") {
+		return nil, errInvalidTarget
+	}
+	rawPath, rawQuery := target, ""
+	if i := strings.IndexByte(target, '?'); i >= 0 {
+		rawPath, rawQuery = target[:i], target[i+1:]
+	}
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return nil, err
+	}
+	return &url.URL{Path: path, RawPath: rawPath, RawQuery: rawQuery}, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/orijtech/authmid"
+)
+
+func TestVerifyMessage(t *testing.T) {
+	ha := newHeaderAuthenticator()
+
+	newSignedMessage := func(secret []byte) *authmid.Message {
+		msg := &authmid.Message{
+			Target: "/topics/orders?partition=3",
+			Header: http.Header{"Test-Access-Timestamp": {"1500000000"}},
+			Body:   strings.NewReader(`{"order": 42}`),
+		}
+		if err := ha.SignMessage(msg, apiKey1, secret); err != nil {
+			t.Fatalf("SignMessage: %v", err)
+		}
+		return msg
+	}
+
+	tests := [...]struct {
+		secret  []byte
+		tamper  func(*authmid.Message)
+		wantErr error
+	}{
+		0: {secret: bAPISecret1, tamper: func(*authmid.Message) {}},
+		1: {secret: bAPISecret2, tamper: func(*authmid.Message) {}, wantErr: authmid.ErrSignatureMismatch},
+		2: {secret: bAPISecret1, tamper: func(m *authmid.Message) { m.Body = strings.NewReader(`{"order": 43}`) }, wantErr: authmid.ErrSignatureMismatch},
+		3: {secret: bAPISecret1, tamper: func(m *authmid.Message) { m.Target = "/topics/refunds?partition=3" }, wantErr: authmid.ErrSignatureMismatch},
+		4: {secret: bAPISecret1, tamper: func(m *authmid.Message) { m.Method = "PUBLISH" }, wantErr: authmid.ErrSignatureMismatch},
+	}
+
+	for i, tt := range tests {
+		msg := newSignedMessage(tt.secret)
+		tt.tamper(msg)
+		principal, err := authmid.Verify(context.Background(), ha, msg)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("#%d: got err=%v want %v", i, err, tt.wantErr)
+			continue
+		}
+		if err == nil && principal.APIKey != apiKey1 {
+			t.Errorf("#%d: got principal %+v", i, principal)
+		}
+		if body, _ := ioutil.ReadAll(msg.Body); len(body) == 0 {
+			t.Errorf("#%d: the body wasn't restored", i)
+		}
+	}
+}
+
+func TestSignMessageMatchesSign(t *testing.T) {
+	body := `{"order": 42}`
+	msg := &authmid.Message{Method: "POST", Target: "/v1/orders", Body: strings.NewReader(body)}
+	got, err := authmid.SignMessage(bAPISecret1, msg, []string{"1500000000"}, authmid.Canonicalization{})
+	if err != nil {
+		t.Fatalf("SignMessage: %v", err)
+	}
+	req, _ := http.NewRequest("POST", "https://orijtech.com/v1/orders", strings.NewReader(body))
+	want, _ := authmid.Sign(bAPISecret1, req, []string{"1500000000"}, authmid.Canonicalization{})
+	if got != want {
+		t.Errorf("got %q want %q, the HTTP request's signature", got, want)
+	}
+}
+
+func TestMessageTargets(t *testing.T) {
+	sign := func(target string) (string, error) {
+		msg := &authmid.Message{Method: "POST", Target: target}
+		return authmid.SignMessage(bAPISecret1, msg, nil, authmid.Canonicalization{})
+	}
+
+	// Each pair once canonicalized alike, losing what url.Parse
+	// took for the host, or for the scheme and opaque part.
+	distinct := [...][2]string{
+		0: {"//a/b", "//zzz/b"},
+		1: {"/a?x=1", "/b?x=1"},
+	}
+	for i, pair := range distinct {
+		sig1, err1 := sign(pair[0])
+		sig2, err2 := sign(pair[1])
+		if err1 != nil || err2 != nil {
+			t.Errorf("#%d: got errors %v, %v", i, err1, err2)
+			continue
+		}
+		if sig1 == sig2 {
+			t.Errorf("#%d: %q and %q have the same signature", i, pair[0], pair[1])
+		}
+	}
+
+	for _, target := range []string{"orders:created", "payments:refund", "topics/orders", "https://orijtech.com/"} {
+		if _, err := sign(target); err == nil {
+			t.Errorf("%q: got no error", target)
+		}
+	}
+	if _, err := sign(""); err != nil {
+		t.Errorf("blank target: %v", err)
+	}
+}
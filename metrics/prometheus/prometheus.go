@@ -0,0 +1,131 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prometheus exports authmid's authentication outcomes and
+// backend lookups as Prometheus metrics.
+//
+// A Collector is both an authmid.AuditSink, for the decisions of the
+// Middlewares, and an authmid.LookupObserver, for backends wrapped with
+// authmid.InstrumentBackend:
+//
+//	c := prometheus.NewCollector("")
+//	registry.MustRegister(c)
+//	backend = authmid.InstrumentBackend("redis", backend, c)
+//	handler, err := authmid.Audit(&authmid.AuditConfig{Sink: c}, authmid.Middleware(vf, next))
+//
+// To also log decisions, combine sinks with authmid.MultiSink.
+package prometheus
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/orijtech/authmid"
+)
+
+type Collector struct {
+	outcomes      *prom.CounterVec
+	checkLatency  *prom.HistogramVec
+	lookups       *prom.CounterVec
+	lookupLatency *prom.HistogramVec
+}
+
+var (
+	_ prom.Collector         = (*Collector)(nil)
+	_ authmid.AuditSink      = (*Collector)(nil)
+	_ authmid.LookupObserver = (*Collector)(nil)
+)
+
+// NewCollector returns a Collector whose metrics are prefixed by
+// namespace, "authmid" if it is blank.
+func NewCollector(namespace string) *Collector {
+	if namespace == "" {
+		namespace = "authmid"
+	}
+	return &Collector{
+		outcomes: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "authentications_total",
+			Help:      "Authentication decisions by outcome and reason.",
+		}, []string{"outcome", "reason"}),
+		checkLatency: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "check_duration_seconds",
+			Help:      "Latency of authenticating a request.",
+			Buckets:   prom.DefBuckets,
+		}, []string{"outcome"}),
+		lookups: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_lookups_total",
+			Help:      "LookupSecret calls by backend and result, hits over hits and misses is the hit ratio.",
+		}, []string{"backend", "result"}),
+		lookupLatency: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "backend_lookup_duration_seconds",
+			Help:      "Latency of LookupSecret by backend.",
+			Buckets:   prom.DefBuckets,
+		}, []string{"backend"}),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	c.outcomes.Describe(ch)
+	c.checkLatency.Describe(ch)
+	c.lookups.Describe(ch)
+	c.lookupLatency.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	c.outcomes.Collect(ch)
+	c.checkLatency.Collect(ch)
+	c.lookups.Collect(ch)
+	c.lookupLatency.Collect(ch)
+}
+
+func (c *Collector) Audit(ev *authmid.AuditEvent) {
+	c.outcomes.WithLabelValues(string(ev.Outcome), reason(ev)).Inc()
+	c.checkLatency.WithLabelValues(string(ev.Outcome)).Observe(ev.Latency.Seconds())
+}
+
+func (c *Collector) ObserveLookup(backend string, latency time.Duration, result authmid.LookupResult) {
+	c.lookups.WithLabelValues(backend, string(result)).Inc()
+	c.lookupLatency.WithLabelValues(backend).Observe(latency.Seconds())
+}
+
+// reason buckets the errors of events into few label values,
+// as their messages include header names and such.
+func reason(ev *authmid.AuditEvent) string {
+	if ev.Outcome == authmid.Allowed {
+		return "ok"
+	}
+	var lockedOut *authmid.LockedOutError
+	switch {
+	case errors.Is(ev.Err, authmid.ErrSignatureMismatch):
+		return "signature_mismatch"
+	case errors.Is(ev.Err, authmid.ErrNoSuchAPIKey):
+		return "no_such_api_key"
+	case errors.As(ev.Err, &lockedOut):
+		return "locked_out"
+	}
+	switch ev.Status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "forbidden"
+	case http.StatusBadRequest:
+		return "bad_request"
+	}
+	return "error"
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 orijtech, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+	"github.com/orijtech/authmid/metrics/prometheus"
+)
+
+func TestCollectorExposition(t *testing.T) {
+	c := prometheus.NewCollector("")
+	registry := prom.NewRegistry()
+	registry.MustRegister(c)
+
+	mem, _ := memory.NewWithMap(map[string]string{"key": "secret"})
+	ha := &authmid.HeaderAuthenticator{
+		Backend:         authmid.InstrumentBackend("memory", mem, c),
+		KeyHeader:       "X-Key",
+		SignatureHeader: "X-Signature",
+	}
+	handler, err := authmid.Audit(&authmid.AuditConfig{Sink: c}, authmid.Middleware(ha, http.NotFoundHandler()))
+	if err != nil {
+		t.Fatalf("Audit: %v", err)
+	}
+
+	for _, cred := range []struct{ key, secret string }{{"key", "secret"}, {"key", "wrong"}, {"absent", "secret"}} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if err := ha.SignRequest(req, cred.key, []byte(cred.secret)); err != nil {
+			t.Fatalf("SignRequest: %v", err)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	srv := httptest.NewServer(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	defer srv.Close()
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	exposition := string(blob)
+
+	for _, want := range []string{
+		`authmid_authentications_total{outcome="allowed",reason="ok"} 1`,
+		`authmid_authentications_total{outcome="denied",reason="signature_mismatch"} 1`,
+		`authmid_authentications_total{outcome="denied",reason="no_such_api_key"} 1`,
+		`authmid_check_duration_seconds_count{outcome="denied"} 2`,
+		`authmid_backend_lookups_total{backend="memory",result="hit"} 2`,
+		`authmid_backend_lookups_total{backend="memory",result="miss"} 1`,
+		`authmid_backend_lookup_duration_seconds_count{backend="memory"} 3`,
+	} {
+		if !strings.Contains(exposition, want) {
+			t.Errorf("missing %q in:\n%s", want, exposition)
+		}
+	}
+}
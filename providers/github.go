@@ -0,0 +1,63 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/orijtech/authmid"
+)
+
+// GitHub verifies the X-Hub-Signature-256 header, a
+// hex HMAC-SHA256 of the body prefixed by "sha256=".
+type GitHub struct {
+	Backend authmid.ReadOnlyBackend
+	Key     string
+}
+
+var (
+	_ authmid.Authenticator          = (*GitHub)(nil)
+	_ authmid.ExcludeMethodAndPather = (*GitHub)(nil)
+	_ Verifier                       = (*GitHub)(nil)
+)
+
+func (gh *GitHub) HeaderValues(http.Header) (values, warnings []string, err error) {
+	return nil, nil, nil
+}
+
+func (gh *GitHub) LookupAPIKey(http.Header) (string, error) {
+	return gh.Key, nil
+}
+
+func (gh *GitHub) Signature(hdr http.Header) (string, error) {
+	value := hdr.Get("X-Hub-Signature-256")
+	if !strings.HasPrefix(value, "sha256=") {
+		return "", errMalformedSignature
+	}
+	return strings.ToLower(strings.TrimPrefix(value, "sha256=")), nil
+}
+
+func (gh *GitHub) LookupSecret(apiKey string) ([]byte, error) {
+	return lookupSecret(gh.Backend, apiKey)
+}
+
+func (gh *GitHub) ExcludeMethodAndPath() bool {
+	return true
+}
+
+func (gh *GitHub) Verify(req *http.Request) error {
+	return authmid.Checker(gh)(req)
+}
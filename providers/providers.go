@@ -0,0 +1,61 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package providers verifies the webhooks of popular providers. GitHub,
+// Slack and Shopify are expressed as authmid.Authenticators and are
+// verified by authmid.Checker, Stripe and Twilio whose schemes don't fit
+// it implement Verifier. Every provider looks its secret up by a fixed
+// Key in a ReadOnlyBackend so that secrets can be rotated in the backend.
+package providers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/orijtech/authmid"
+)
+
+type Verifier interface {
+	Verify(req *http.Request) error
+}
+
+// Middleware verifies requests with v before passing them on to next.
+func Middleware(v Verifier, next http.Handler) http.Handler {
+	return authmid.CheckerMiddleware(v.Verify, next)
+}
+
+var (
+	errMalformedTimestamp = errors.New("malformed webhook timestamp")
+	errMalformedSignature = errors.New("malformed webhook signature")
+	errNilBackend         = errors.New("expecting a non-nil backend")
+)
+
+type staticSecret []byte
+
+// Secret returns a ReadOnlyBackend that returns secret for every key,
+// for when a single endpoint secret is configured.
+func Secret(secret string) authmid.ReadOnlyBackend {
+	return staticSecret(secret)
+}
+
+func (ss staticSecret) LookupSecret(string) ([]byte, error) {
+	return []byte(ss), nil
+}
+
+func lookupSecret(backend authmid.ReadOnlyBackend, key string) ([]byte, error) {
+	if backend == nil {
+		return nil, errNilBackend
+	}
+	return backend.LookupSecret(key)
+}
@@ -0,0 +1,179 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/providers"
+)
+
+func newReq(method, target, body string, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return req
+}
+
+func TestGitHub(t *testing.T) {
+	// From GitHub's "Validating webhook deliveries" documentation.
+	gh := &providers.GitHub{Backend: providers.Secret("It's a Secret to Everybody")}
+	sig := "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+
+	tests := [...]struct {
+		body, signature string
+		wantErr         bool
+	}{
+		0: {body: "Hello, World!", signature: sig},
+		1: {body: "Hello, World?", signature: sig, wantErr: true},
+		2: {body: "Hello, World!", signature: strings.TrimPrefix(sig, "sha256="), wantErr: true},
+		3: {body: "Hello, World!", signature: "", wantErr: true},
+	}
+	for i, tt := range tests {
+		req := newReq("POST", "/hooks/github", tt.body, map[string]string{
+			"X-Hub-Signature-256": tt.signature,
+			"X-GitHub-Event":      "push",
+		})
+		err := gh.Verify(req)
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("#%d: gotErr=%v wantErr=%v; err:(%v)", i, gotErr, tt.wantErr, err)
+		}
+	}
+}
+
+func TestSlack(t *testing.T) {
+	// From Slack's "Verifying requests from Slack" documentation.
+	body := "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+	sig := "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503"
+	signedAt := time.Unix(1531420618, 0)
+
+	tests := [...]struct {
+		now       time.Time
+		timestamp string
+		wantErr   error
+	}{
+		0: {now: signedAt.Add(time.Minute), timestamp: "1531420618"},
+		1: {now: signedAt.Add(time.Hour), timestamp: "1531420618", wantErr: authmid.ErrTimestampOutsideTolerance},
+		2: {now: signedAt, timestamp: "1531420619", wantErr: authmid.ErrSignatureMismatch},
+	}
+	for i, tt := range tests {
+		now := tt.now
+		s := &providers.Slack{
+			Backend: providers.Secret("8f742231b10e8888abcd99yyyzzz85a5"),
+			Now:     func() time.Time { return now },
+		}
+		req := newReq("POST", "/hooks/slack", body, map[string]string{
+			"X-Slack-Request-Timestamp": tt.timestamp,
+			"X-Slack-Signature":         sig,
+		})
+		if err := s.Verify(req); err != tt.wantErr {
+			t.Errorf("#%d: got err=%v want %v", i, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTwilio(t *testing.T) {
+	// From Twilio's "Webhooks security" documentation.
+	body := "CallSid=CA1234567890ABCDE&Caller=%2B12349013030&Digits=1234&From=%2B12349013030&To=%2B18005551212"
+	tw := &providers.Twilio{Backend: providers.Secret("12345"), BaseURL: "https://mycompany.com"}
+
+	tests := [...]struct {
+		target, signature string
+		wantErr           bool
+	}{
+		0: {target: "/myapp.php?foo=1&bar=2", signature: "0/KCTR6DLpKmkAf8muzZqo1nDgQ="},
+		1: {target: "/myapp.php?foo=1&bar=3", signature: "0/KCTR6DLpKmkAf8muzZqo1nDgQ=", wantErr: true},
+		2: {target: "/myapp.php?foo=1&bar=2", signature: "not base64!", wantErr: true},
+	}
+	for i, tt := range tests {
+		req := newReq("POST", tt.target, body, map[string]string{
+			"Content-Type":       "application/x-www-form-urlencoded",
+			"X-Twilio-Signature": tt.signature,
+		})
+		err := tw.Verify(req)
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("#%d: gotErr=%v wantErr=%v; err:(%v)", i, gotErr, tt.wantErr, err)
+		}
+	}
+}
+
+func TestStripe(t *testing.T) {
+	const secret = "whsec_test_secret"
+	body := `{"id": "evt_test_webhook", "object": "event"}`
+	now := time.Unix(1492774577, 0)
+	// Generated by Stripe's own stripe-go, webhook.GenerateTestSignedPayload,
+	// with secret and with "whsec_old_secret". The v0 signature is from
+	// Stripe's "Verify webhook signatures manually" documentation.
+	v1 := "c137b1b62277d523cf8fed4dfbd0170a9a5b8a380e00cc3711d4bf0652f2ce7a"
+	other := "52320f930420b8ccb95092a79c17be52d55e34e87d4372a190a34598e3b362fc"
+
+	tests := [...]struct {
+		header  string
+		now     time.Time
+		wantErr error
+	}{
+		0: {header: "t=1492774577,v1=" + v1, now: now},
+		1: {header: "t=1492774577,v1=" + other + ",v1=" + v1 + ",v0=6ffbb59b2300aae63f272406069a9788598b792a944a07aba816edb039989a39", now: now},
+		2: {header: "t=1492774577,v1=" + other, now: now, wantErr: authmid.ErrSignatureMismatch},
+		3: {header: "t=1492774577,v1=" + v1, now: now.Add(10 * time.Minute), wantErr: authmid.ErrTimestampOutsideTolerance},
+		4: {header: "t=1492774578,v1=" + v1, now: now, wantErr: authmid.ErrSignatureMismatch},
+	}
+	for i, tt := range tests {
+		now := tt.now
+		s := &providers.Stripe{Backend: providers.Secret(secret), Now: func() time.Time { return now }}
+		req := newReq("POST", "/hooks/stripe", body, map[string]string{"Stripe-Signature": tt.header})
+		if err := s.Verify(req); err != tt.wantErr {
+			t.Errorf("#%d: got err=%v want %v", i, err, tt.wantErr)
+		}
+	}
+}
+
+func TestShopifyMiddleware(t *testing.T) {
+	const secret = "shpss_test_secret"
+	body := `{"id": 820982911946154508, "email": "jon@example.com"}`
+	sh := &providers.Shopify{Backend: providers.Secret(secret)}
+	// Computed with the Node.js snippet of Shopify's "Verify webhooks"
+	// documentation, for secret and for "wrong".
+	h := providers.Middleware(sh, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := [...]struct {
+		signature string
+		wantCode  int
+	}{
+		0: {signature: "Ao9cjZXJ7KV31Qtuy1wDZJylT4JM29FkZSd+d9tNi1w=", wantCode: http.StatusNoContent},
+		1: {signature: "b19iReEISXhfTqJYezXveaD+sZ6nckcYhaOEaYVB7F8=", wantCode: http.StatusBadRequest},
+		// Hex rather than base64.
+		2: {signature: "028f5c8d95c9eca577d50b6ecb5c03649ca54f824cdbd16465277e77db4d8b5c", wantCode: http.StatusBadRequest},
+	}
+	for i, tt := range tests {
+		req := newReq("POST", "/hooks/shopify", body, map[string]string{
+			"X-Shopify-Hmac-Sha256": tt.signature,
+			"X-Shopify-Topic":       "orders/create",
+		})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Errorf("#%d: got %d want %d; body: %s", i, rec.Code, tt.wantCode, rec.Body)
+		}
+	}
+}
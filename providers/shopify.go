@@ -0,0 +1,65 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/orijtech/authmid"
+)
+
+// Shopify verifies the X-Shopify-Hmac-Sha256 header, a
+// base64 encoded HMAC-SHA256 of the body.
+type Shopify struct {
+	Backend authmid.ReadOnlyBackend
+	Key     string
+}
+
+var (
+	_ authmid.Authenticator          = (*Shopify)(nil)
+	_ authmid.ExcludeMethodAndPather = (*Shopify)(nil)
+	_ Verifier                       = (*Shopify)(nil)
+)
+
+func (s *Shopify) HeaderValues(http.Header) (values, warnings []string, err error) {
+	return nil, nil, nil
+}
+
+func (s *Shopify) LookupAPIKey(http.Header) (string, error) {
+	return s.Key, nil
+}
+
+// Signature returns the signature hex encoded, as Checker computes it.
+func (s *Shopify) Signature(hdr http.Header) (string, error) {
+	mac, err := base64.StdEncoding.DecodeString(hdr.Get("X-Shopify-Hmac-Sha256"))
+	if err != nil || len(mac) == 0 {
+		return "", errMalformedSignature
+	}
+	return fmt.Sprintf("%x", mac), nil
+}
+
+func (s *Shopify) LookupSecret(apiKey string) ([]byte, error) {
+	return lookupSecret(s.Backend, apiKey)
+}
+
+func (s *Shopify) ExcludeMethodAndPath() bool {
+	return true
+}
+
+func (s *Shopify) Verify(req *http.Request) error {
+	return authmid.Checker(s)(req)
+}
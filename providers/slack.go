@@ -0,0 +1,80 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/orijtech/authmid"
+)
+
+// Slack verifies the X-Slack-Signature header, a hex HMAC-SHA256 of
+// "v0:" + X-Slack-Request-Timestamp + ":" + body prefixed by "v0=".
+type Slack struct {
+	Backend authmid.ReadOnlyBackend
+	Key     string
+
+	// Tolerance bounds the age of requests, it defaults to 5 minutes
+	// and a negative value disables the check.
+	Tolerance time.Duration
+
+	// Now if set overrides time.Now.
+	Now func() time.Time
+}
+
+var (
+	_ authmid.Authenticator          = (*Slack)(nil)
+	_ authmid.ExcludeMethodAndPather = (*Slack)(nil)
+	_ Verifier                       = (*Slack)(nil)
+)
+
+func (s *Slack) HeaderValues(hdr http.Header) (values, warnings []string, err error) {
+	timestamp := hdr.Get("X-Slack-Request-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, nil, errMalformedTimestamp
+	}
+	if err := authmid.WithinTolerance(ts, s.Tolerance, s.Now); err != nil {
+		return nil, nil, err
+	}
+	return []string{"v0:" + timestamp + ":"}, nil, nil
+}
+
+func (s *Slack) LookupAPIKey(http.Header) (string, error) {
+	return s.Key, nil
+}
+
+func (s *Slack) Signature(hdr http.Header) (string, error) {
+	value := hdr.Get("X-Slack-Signature")
+	if !strings.HasPrefix(value, "v0=") {
+		return "", errMalformedSignature
+	}
+	return strings.TrimPrefix(value, "v0="), nil
+}
+
+func (s *Slack) LookupSecret(apiKey string) ([]byte, error) {
+	return lookupSecret(s.Backend, apiKey)
+}
+
+func (s *Slack) ExcludeMethodAndPath() bool {
+	return true
+}
+
+func (s *Slack) Verify(req *http.Request) error {
+	return authmid.Checker(s)(req)
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/orijtech/authmid"
+)
+
+// Stripe verifies the Stripe-Signature header of the form
+// "t=<timestamp>,v1=<signature>[,v1=<signature>...]" where each
+// signature is a hex HMAC-SHA256 of timestamp + "." + body. Multiple
+// v1 signatures are sent while an endpoint secret is being rolled.
+type Stripe struct {
+	Backend authmid.ReadOnlyBackend
+	Key     string
+
+	// Tolerance bounds the age of events, it defaults to 5 minutes
+	// and a negative value disables the check.
+	Tolerance time.Duration
+
+	// Now if set overrides time.Now.
+	Now func() time.Time
+}
+
+var _ Verifier = (*Stripe)(nil)
+
+func (s *Stripe) Verify(req *http.Request) error {
+	timestamp, signatures, err := parseStripeSignature(req.Header.Get("Stripe-Signature"))
+	if err != nil {
+		return err
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errMalformedTimestamp
+	}
+	secret, err := lookupSecret(s.Backend, s.Key)
+	if err != nil {
+		return err
+	}
+	body, err := authmid.ReadBody(req)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	want := mac.Sum(nil)
+
+	matched := false
+	for _, signature := range signatures {
+		got, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(got, want) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return authmid.ErrSignatureMismatch
+	}
+	// Only check the timestamp once it is known to be authentic.
+	return authmid.WithinTolerance(ts, s.Tolerance, s.Now)
+}
+
+func parseStripeSignature(header string) (timestamp string, signatures []string, err error) {
+	for _, pair := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+	if timestamp == "" {
+		return "", nil, errMalformedTimestamp
+	}
+	if len(signatures) == 0 {
+		return "", nil, errMalformedSignature
+	}
+	return timestamp, signatures, nil
+}
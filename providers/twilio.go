@@ -0,0 +1,96 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/orijtech/authmid"
+)
+
+// Twilio verifies the X-Twilio-Signature header, a base64 encoded
+// HMAC-SHA1 keyed by the account's auth token over the full request URL
+// followed by each form parameter's name and value, sorted by name.
+type Twilio struct {
+	Backend authmid.ReadOnlyBackend
+	Key     string
+
+	// BaseURL if set e.g. "https://example.com" replaces the scheme
+	// and host of requests, which are otherwise derived from the
+	// request and are wrong behind proxies.
+	BaseURL string
+}
+
+var _ Verifier = (*Twilio)(nil)
+
+func (t *Twilio) Verify(req *http.Request) error {
+	got, err := base64.StdEncoding.DecodeString(req.Header.Get("X-Twilio-Signature"))
+	if err != nil || len(got) == 0 {
+		return errMalformedSignature
+	}
+	secret, err := lookupSecret(t.Backend, t.Key)
+	if err != nil {
+		return err
+	}
+	body, err := authmid.ReadBody(req)
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write([]byte(t.fullURL(req)))
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		params, err := url.ParseQuery(string(body))
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			values := append([]string(nil), params[name]...)
+			sort.Strings(values)
+			for _, value := range values {
+				mac.Write([]byte(name + value))
+			}
+		}
+	}
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return authmid.ErrSignatureMismatch
+	}
+	return nil
+}
+
+func (t *Twilio) fullURL(req *http.Request) string {
+	if t.BaseURL != "" {
+		return strings.TrimSuffix(t.BaseURL, "/") + req.URL.RequestURI()
+	}
+	if req.URL.IsAbs() {
+		return req.URL.String()
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + req.URL.RequestURI()
+}
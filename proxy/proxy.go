@@ -0,0 +1,122 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package proxy fronts services that can't verify signatures themselves:
+// it verifies requests and forwards those that pass to the upstream
+// service, with trusted headers identifying the authenticated API key.
+package proxy
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/orijtech/authmid"
+)
+
+const (
+	DefaultKeyHeader    = "X-Authenticated-Key"
+	DefaultScopesHeader = "X-Authenticated-Scopes"
+)
+
+type Config struct {
+	Upstream      *url.URL
+	Authenticator authmid.Authenticator
+
+	// StripHeaders are removed before forwarding, along with the
+	// credentials that upstream has no use for, if Authenticator names
+	// them like *authmid.HeaderAuthenticator's CredentialHeaders.
+	StripHeaders []string
+
+	// ForwardCredentials keeps the credential headers, e.g. for
+	// upstreams that verify requests again.
+	ForwardCredentials bool
+
+	// KeyHeader and ScopesHeader carry the authenticated API key and its
+	// comma separated scopes upstream, they default to DefaultKeyHeader
+	// and DefaultScopesHeader. Clients' own values are always dropped.
+	KeyHeader    string
+	ScopesHeader string
+
+	// Transport defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// ErrorLog is passed on to httputil.ReverseProxy.
+	ErrorLog *log.Logger
+}
+
+// credentialHeaderer is an Authenticator that names the headers that
+// carry its credentials.
+type credentialHeaderer interface {
+	CredentialHeaders() []string
+}
+
+var (
+	errNilConfig        = errors.New("expecting a non-nil config")
+	errNilUpstream      = errors.New("expecting a non-nil upstream URL")
+	errNilAuthenticator = errors.New("expecting a non-nil authenticator")
+)
+
+// New returns a handler that verifies requests with cfg.Authenticator
+// and forwards those that pass to cfg.Upstream.
+func New(cfg *Config) (http.Handler, error) {
+	if cfg == nil {
+		return nil, errNilConfig
+	}
+	if cfg.Upstream == nil {
+		return nil, errNilUpstream
+	}
+	if cfg.Authenticator == nil {
+		return nil, errNilAuthenticator
+	}
+	keyHeader := cfg.KeyHeader
+	if keyHeader == "" {
+		keyHeader = DefaultKeyHeader
+	}
+	scopesHeader := cfg.ScopesHeader
+	if scopesHeader == "" {
+		scopesHeader = DefaultScopesHeader
+	}
+	strip := append([]string{keyHeader, scopesHeader}, cfg.StripHeaders...)
+	if ch, ok := cfg.Authenticator.(credentialHeaderer); ok && !cfg.ForwardCredentials {
+		strip = append(strip, ch.CredentialHeaders()...)
+	}
+
+	upstream := cfg.Upstream
+	rp := &httputil.ReverseProxy{
+		// Rewrite rather than Director, since by the time Rewrite is
+		// called the hop-by-hop headers are gone: clients can't have
+		// the identity headers set here removed through "Connection".
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(upstream)
+			pr.Out.Host = pr.In.Host
+			pr.SetXForwarded()
+			for _, name := range strip {
+				pr.Out.Header.Del(name)
+			}
+			if principal, ok := authmid.PrincipalFromContext(pr.In.Context()); ok {
+				pr.Out.Header.Set(keyHeader, principal.APIKey)
+				if len(principal.Scopes) > 0 {
+					pr.Out.Header.Set(scopesHeader, strings.Join(principal.Scopes, ","))
+				}
+			}
+		},
+	}
+	rp.Transport = cfg.Transport
+	rp.ErrorLog = cfg.ErrorLog
+	return authmid.Middleware(cfg.Authenticator, rp), nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+	"github.com/orijtech/authmid/proxy"
+)
+
+func TestProxy(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer upstream.Close()
+
+	backend, _ := memory.NewWithMap(map[string]string{"partner": "partner-secret"})
+	backend.SetScopes("partner", []string{"orders:read", "orders:write"})
+	ha := &authmid.HeaderAuthenticator{
+		Backend:         backend,
+		KeyHeader:       "X-Authmid-Key",
+		SignatureHeader: "X-Authmid-Signature",
+		Headers:         []authmid.HeaderSpec{{Name: "X-Authmid-Timestamp"}},
+	}
+	upstreamURL, _ := url.Parse(upstream.URL)
+	handler, err := proxy.New(&proxy.Config{
+		Upstream:      upstreamURL,
+		Authenticator: ha,
+		StripHeaders:  []string{"X-Authmid-Timestamp"},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	front := httptest.NewServer(handler)
+	defer front.Close()
+
+	for i, tt := range []struct {
+		secret   string
+		wantCode int
+	}{
+		{secret: "partner-secret", wantCode: http.StatusOK},
+		{secret: "guessed", wantCode: http.StatusBadRequest},
+	} {
+		got, gotBody = nil, nil
+		req, _ := http.NewRequest("POST", front.URL+"/v1/orders?page=2", bytes.NewReader([]byte(`{"id": 7}`)))
+		req.Header.Set("X-Authmid-Timestamp", "1500000000")
+		// Clients can't vouch for themselves.
+		req.Header.Set(proxy.DefaultKeyHeader, "admin")
+		req.Header.Set(proxy.DefaultScopesHeader, "admin")
+		if err := ha.SignRequest(req, "partner", []byte(tt.secret)); err != nil {
+			t.Fatalf("#%d: SignRequest: %v", i, err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.wantCode {
+			t.Errorf("#%d: got %d want %d", i, res.StatusCode, tt.wantCode)
+		}
+		if tt.wantCode != http.StatusOK {
+			if got != nil {
+				t.Errorf("#%d: the request was forwarded", i)
+			}
+			continue
+		}
+		if got == nil {
+			t.Fatalf("#%d: the request wasn't forwarded", i)
+		}
+		if k, s := got.Header.Get(proxy.DefaultKeyHeader), got.Header.Get(proxy.DefaultScopesHeader); k != "partner" || s != "orders:read,orders:write" {
+			t.Errorf("#%d: got identity %q %q", i, k, s)
+		}
+		for _, name := range []string{"X-Authmid-Key", "X-Authmid-Signature", "X-Authmid-Timestamp"} {
+			if v := got.Header.Get(name); v != "" {
+				t.Errorf("#%d: %s wasn't stripped: %q", i, name, v)
+			}
+		}
+		if got.URL.RequestURI() != "/v1/orders?page=2" || string(gotBody) != `{"id": 7}` {
+			t.Errorf("#%d: got %s %q", i, got.URL.RequestURI(), gotBody)
+		}
+	}
+}
+
+func TestProxyIdentityNotHopByHop(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer upstream.Close()
+
+	backend, _ := memory.NewWithMap(map[string]string{"partner": "partner-secret"})
+	ha := &authmid.HeaderAuthenticator{Backend: backend, KeyHeader: "X-Authmid-Key", SignatureHeader: "X-Authmid-Signature"}
+	upstreamURL, _ := url.Parse(upstream.URL)
+	handler, err := proxy.New(&proxy.Config{Upstream: upstreamURL, Authenticator: ha})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	front := httptest.NewServer(handler)
+	defer front.Close()
+
+	req, _ := http.NewRequest("GET", front.URL+"/v1/orders", nil)
+	// Headers named by Connection are dropped when forwarding,
+	// that mustn't include those the proxy sets.
+	req.Header.Set("Connection", proxy.DefaultKeyHeader)
+	if err := ha.SignRequest(req, "partner", []byte("partner-secret")); err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d want %d", res.StatusCode, http.StatusOK)
+	}
+	if k := got.Get(proxy.DefaultKeyHeader); k != "partner" {
+		t.Errorf("got identity %q want %q", k, "partner")
+	}
+}
+
+func TestProxyForwardCredentials(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer upstream.Close()
+
+	backend, _ := memory.NewWithMap(map[string]string{"partner": "partner-secret"})
+	ha := &authmid.HeaderAuthenticator{Backend: backend, KeyHeader: "X-Authmid-Key", SignatureHeader: "X-Authmid-Signature"}
+	upstreamURL, _ := url.Parse(upstream.URL)
+	for i, forward := range []bool{false, true} {
+		handler, err := proxy.New(&proxy.Config{Upstream: upstreamURL, Authenticator: ha, ForwardCredentials: forward})
+		if err != nil {
+			t.Fatalf("#%d: New: %v", i, err)
+		}
+		req := httptest.NewRequest("GET", "/v1/orders", nil)
+		if err := ha.SignRequest(req, "partner", []byte("partner-secret")); err != nil {
+			t.Fatalf("#%d: SignRequest: %v", i, err)
+		}
+		got = nil
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || got == nil {
+			t.Fatalf("#%d: got %d, forwarded: %v", i, rec.Code, got != nil)
+		}
+		for _, name := range ha.CredentialHeaders() {
+			if kept := got.Get(name) != ""; kept != forward {
+				t.Errorf("#%d: %s kept: %v want %v", i, name, kept, forward)
+			}
+		}
+	}
+}
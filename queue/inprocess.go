@@ -0,0 +1,98 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Sealed is an event in flight, with its envelope.
+type Sealed struct {
+	Event    *Event
+	Envelope *Envelope
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, s *Sealed) error
+}
+
+type Consumer interface {
+	Consume(ctx context.Context) (*Sealed, error)
+}
+
+// InProcess is a buffered queue within the process, a stand-in
+// for a message broker in tests.
+type InProcess struct {
+	closeOnce sync.Once
+	ch        chan *Sealed
+	done      chan struct{}
+}
+
+var (
+	_ Publisher = (*InProcess)(nil)
+	_ Consumer  = (*InProcess)(nil)
+)
+
+var ErrClosed = errors.New("queue closed")
+
+func NewInProcess(capacity int) *InProcess {
+	return &InProcess{ch: make(chan *Sealed, capacity), done: make(chan struct{})}
+}
+
+// Publish blocks while the queue is full.
+func (q *InProcess) Publish(ctx context.Context, s *Sealed) error {
+	select {
+	case <-q.done:
+		return ErrClosed
+	default:
+	}
+	select {
+	case q.ch <- s:
+		return nil
+	case <-q.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Consume blocks until an event is published, or returns
+// ErrClosed once the queue is closed and drained.
+func (q *InProcess) Consume(ctx context.Context) (*Sealed, error) {
+	select {
+	case s := <-q.ch:
+		return s, nil
+	default:
+	}
+	select {
+	case s := <-q.ch:
+		return s, nil
+	case <-q.done:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *InProcess) Close() error {
+	err := ErrClosed
+	q.closeOnce.Do(func() {
+		close(q.done)
+		err = nil
+	})
+	return err
+}
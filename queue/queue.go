@@ -0,0 +1,139 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package queue signs events that travel through message queues, such as
+// Kafka, NATS or SQS, into detached envelopes that consumers verify with
+// the same API keys and secrets as HTTP requests and webhooks.
+//
+// An envelope signs the event's topic, timestamp, attributes and payload,
+// it travels alongside the event, e.g. JSON encoded in a message attribute.
+package queue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/orijtech/authmid"
+)
+
+type Event struct {
+	Topic      string
+	Payload    []byte
+	Attributes map[string]string
+}
+
+// Envelope is the detached signature of an Event.
+type Envelope struct {
+	APIKey    string `json:"api_key"`
+	Timestamp int64  `json:"timestamp"`
+	Signature string `json:"signature"`
+}
+
+const (
+	keyHeader       = "Authmid-Key"
+	signatureHeader = "Authmid-Signature"
+	eventHeader     = "Authmid-Event"
+)
+
+// scheme signs the event's metadata, carried in eventHeader,
+// and its payload as the body.
+func scheme(backend authmid.ReadOnlyBackend) *authmid.HeaderAuthenticator {
+	return &authmid.HeaderAuthenticator{
+		Backend:         backend,
+		KeyHeader:       keyHeader,
+		SignatureHeader: signatureHeader,
+		Headers:         []authmid.HeaderSpec{{Name: eventHeader}},
+		Canonical:       authmid.Canonicalization{ExcludeMethodAndPath: true},
+	}
+}
+
+var errNilEvent = errors.New("expecting a non-nil event")
+
+func message(ev *Event, timestamp int64) *authmid.Message {
+	meta := url.Values{"topic": {ev.Topic}, "timestamp": {strconv.FormatInt(timestamp, 10)}}
+	attrs := make(url.Values, len(ev.Attributes))
+	for key, value := range ev.Attributes {
+		attrs.Set(key, value)
+	}
+	// Both are percent-encoded and sorted, so the newlines delimit them unambiguously.
+	hdr := http.Header{eventHeader: {meta.Encode() + "\n" + attrs.Encode() + "\n"}}
+	return &authmid.Message{Header: hdr, Body: bytes.NewReader(ev.Payload)}
+}
+
+// Signer seals events with the secret of APIKey, from Backend.
+type Signer struct {
+	APIKey  string
+	Backend authmid.ReadOnlyBackend
+
+	// Now if set replaces time.Now, for tests.
+	Now func() time.Time
+}
+
+func (s *Signer) Seal(ctx context.Context, ev *Event) (*Envelope, error) {
+	if ev == nil {
+		return nil, errNilEvent
+	}
+	secret, err := authmid.LookupSecretContext(ctx, s.Backend, s.APIKey)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	env := &Envelope{APIKey: s.APIKey, Timestamp: now().Unix()}
+	msg := message(ev, env.Timestamp)
+	if err := scheme(s.Backend).SignMessage(msg, s.APIKey, secret); err != nil {
+		return nil, err
+	}
+	env.Signature = msg.Header.Get(signatureHeader)
+	return env, nil
+}
+
+var errNilEnvelope = errors.New("expecting a non-nil envelope")
+
+// Verifier opens envelopes with the secrets in Backend.
+type Verifier struct {
+	Backend authmid.ReadOnlyBackend
+
+	// Tolerance bounds how old, or how far in the future, an event's
+	// timestamp may be. It defaults to 5 minutes and negative values
+	// disable the check, e.g. to replay stored events.
+	Tolerance time.Duration
+
+	// Now if set replaces time.Now, for tests.
+	Now func() time.Time
+}
+
+// Open verifies that env signs ev and returns the Principal that sealed it.
+func (v *Verifier) Open(ctx context.Context, ev *Event, env *Envelope) (*authmid.Principal, error) {
+	if ev == nil {
+		return nil, errNilEvent
+	}
+	if env == nil {
+		return nil, errNilEnvelope
+	}
+	if err := authmid.WithinTolerance(env.Timestamp, v.Tolerance, v.Now); err != nil {
+		return nil, err
+	}
+	msg := message(ev, env.Timestamp)
+	msg.Header.Set(keyHeader, env.APIKey)
+	msg.Header.Set(signatureHeader, env.Signature)
+	return authmid.Verify(ctx, scheme(v.Backend), msg)
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+	"github.com/orijtech/authmid/queue"
+)
+
+func TestSealAndOpen(t *testing.T) {
+	backend, _ := memory.NewWithMap(map[string]string{"billing": "billing-secret"})
+	now := time.Unix(1500000000, 0)
+	signer := &queue.Signer{APIKey: "billing", Backend: backend, Now: func() time.Time { return now }}
+	verifier := &queue.Verifier{Backend: backend, Now: func() time.Time { return now.Add(time.Minute) }}
+
+	tests := [...]struct {
+		tamper  func(*queue.Sealed)
+		wantErr error
+	}{
+		0: {tamper: func(*queue.Sealed) {}},
+		1: {tamper: func(s *queue.Sealed) { s.Event.Payload = []byte(`{"amount": 1000}`) }, wantErr: authmid.ErrSignatureMismatch},
+		2: {tamper: func(s *queue.Sealed) { s.Event.Topic = "refunds" }, wantErr: authmid.ErrSignatureMismatch},
+		3: {tamper: func(s *queue.Sealed) { s.Event.Attributes["currency"] = "EUR" }, wantErr: authmid.ErrSignatureMismatch},
+		4: {tamper: func(s *queue.Sealed) { s.Event.Attributes["extra"] = "1" }, wantErr: authmid.ErrSignatureMismatch},
+		5: {tamper: func(s *queue.Sealed) { s.Envelope.Timestamp++ }, wantErr: authmid.ErrSignatureMismatch},
+		6: {tamper: func(s *queue.Sealed) { s.Envelope.Timestamp -= 3600 }, wantErr: authmid.ErrTimestampOutsideTolerance},
+		7: {tamper: func(s *queue.Sealed) { s.Envelope.APIKey = "shipping" }, wantErr: authmid.ErrNoSuchAPIKey},
+	}
+
+	q := queue.NewInProcess(1)
+	defer q.Close()
+	ctx := context.Background()
+	for i, tt := range tests {
+		ev := &queue.Event{
+			Topic:      "charges",
+			Payload:    []byte(`{"amount": 100}`),
+			Attributes: map[string]string{"currency": "USD", "region": "eu-west"},
+		}
+		env, err := signer.Seal(ctx, ev)
+		if err != nil {
+			t.Fatalf("#%d: Seal: %v", i, err)
+		}
+		if err := q.Publish(ctx, &queue.Sealed{Event: ev, Envelope: env}); err != nil {
+			t.Fatalf("#%d: Publish: %v", i, err)
+		}
+
+		sealed, err := q.Consume(ctx)
+		if err != nil {
+			t.Fatalf("#%d: Consume: %v", i, err)
+		}
+		// Envelopes travel JSON encoded.
+		blob, _ := json.Marshal(sealed.Envelope)
+		sealed.Envelope = new(queue.Envelope)
+		if err := json.Unmarshal(blob, sealed.Envelope); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		tt.tamper(sealed)
+
+		principal, err := verifier.Open(ctx, sealed.Event, sealed.Envelope)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("#%d: got err=%v want %v", i, err, tt.wantErr)
+			continue
+		}
+		if err == nil && principal.APIKey != "billing" {
+			t.Errorf("#%d: got principal %+v", i, principal)
+		}
+	}
+}
+
+func TestInProcessClose(t *testing.T) {
+	q := queue.NewInProcess(1)
+	ctx := context.Background()
+	if err := q.Publish(ctx, &queue.Sealed{}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	q.Close()
+	if _, err := q.Consume(ctx); err != nil {
+		t.Errorf("expected the queue to be drained after closing, got %v", err)
+	}
+	if _, err := q.Consume(ctx); err != queue.ErrClosed {
+		t.Errorf("got %v want ErrClosed", err)
+	}
+	if err := q.Publish(ctx, &queue.Sealed{}); err != queue.ErrClosed {
+		t.Errorf("got %v want ErrClosed", err)
+	}
+}
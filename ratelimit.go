@@ -0,0 +1,169 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limit is a token bucket that refills at Rate tokens per second
+// and holds at most Burst tokens.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+var ErrInvalidLimit = errors.New("expecting a limit with a positive rate and burst")
+
+// Validate rejects limits whose buckets would never refill or never hold
+// a token: a zero Rate would put off retries for centuries.
+func (l Limit) Validate() error {
+	if !(l.Rate > 0) || l.Burst < 1 {
+		return ErrInvalidLimit
+	}
+	return nil
+}
+
+// RateLimiter takes a token from the bucket of key. When the bucket is
+// empty it returns false and how long until a token will be available.
+type RateLimiter interface {
+	Allow(key string, limit Limit) (ok bool, retryAfter time.Duration, err error)
+}
+
+// LimitBackend is optionally implemented by backends that store per-key
+// limit overrides alongside secrets. A nil Limit means no override.
+type LimitBackend interface {
+	LookupLimit(apiKey string) (*Limit, error)
+}
+
+type LimitWriter interface {
+	SetLimit(apiKey string, limit *Limit) error
+}
+
+type RateLimitConfig struct {
+	Limiter RateLimiter
+
+	// Default applies to API keys without an override in Overrides.
+	Default Limit
+
+	// Overrides if set is consulted for per-key limits.
+	Overrides LimitBackend
+}
+
+var (
+	errNilRateLimitConfig = errors.New("expecting a non-nil rate limit config")
+	errNilRateLimiter     = errors.New("expecting a non-nil rate limiter")
+)
+
+// RateLimit limits the requests of each Principal that Middleware
+// authenticated, those over their limit are rejected with
+// 429 Too Many Requests and a Retry-After header.
+func RateLimit(cfg *RateLimitConfig, next http.Handler) (http.Handler, error) {
+	if cfg == nil {
+		return nil, errNilRateLimitConfig
+	}
+	if cfg.Limiter == nil {
+		return nil, errNilRateLimiter
+	}
+	if err := cfg.Default.Validate(); err != nil {
+		return nil, err
+	}
+	rcfg := *cfg
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			http.Error(w, "unauthenticated request", http.StatusUnauthorized)
+			return
+		}
+		limit, err := rcfg.limitFor(principal.APIKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		allowed, retryAfter, err := rcfg.Limiter.Allow(principal.APIKey, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			w.Header().Set("Retry-After", RetryAfter(retryAfter))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
+
+func (cfg *RateLimitConfig) limitFor(apiKey string) (Limit, error) {
+	if cfg.Overrides == nil {
+		return cfg.Default, nil
+	}
+	override, err := cfg.Overrides.LookupLimit(apiKey)
+	if err != nil || override == nil {
+		return cfg.Default, err
+	}
+	if err := override.Validate(); err != nil {
+		return Limit{}, err
+	}
+	return *override, nil
+}
+
+// RetryAfter formats d as the value of a Retry-After header, which is
+// in whole seconds, rounding up.
+func RetryAfter(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// TokenBucket is the state of a bucket, shared by the RateLimiter
+// implementations so that they agree on the arithmetic.
+type TokenBucket struct {
+	Tokens float64
+	Last   time.Time
+}
+
+// Take refills b up to now and then takes a token if there is one,
+// otherwise it reports how long until there will be.
+func (b *TokenBucket) Take(limit Limit, now time.Time) (bool, time.Duration) {
+	burst := float64(limit.Burst)
+	if b.Last.IsZero() {
+		b.Tokens = burst
+	} else if elapsed := now.Sub(b.Last).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(burst, b.Tokens+elapsed*limit.Rate)
+	}
+	b.Last = now
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return true, 0
+	}
+	if limit.Rate <= 0 {
+		// The bucket never refills.
+		return false, time.Duration(math.MaxInt64)
+	}
+	wait := (1 - b.Tokens) / limit.Rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// Full reports whether b will have refilled to Burst by now, when it's
+// indistinguishable from a new bucket and can be forgotten.
+func (b *TokenBucket) Full(limit Limit, now time.Time) bool {
+	if b.Last.IsZero() {
+		return true
+	}
+	return b.Tokens+now.Sub(b.Last).Seconds()*limit.Rate >= float64(limit.Burst)
+}
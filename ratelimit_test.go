@@ -0,0 +1,119 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+)
+
+func TestTokenBucketTake(t *testing.T) {
+	limit := authmid.Limit{Rate: 2, Burst: 2}
+	start := time.Unix(1500000000, 0)
+	var b authmid.TokenBucket
+
+	tests := [...]struct {
+		at        time.Duration
+		wantOK    bool
+		wantRetry time.Duration
+	}{
+		0: {at: 0, wantOK: true},
+		1: {at: 0, wantOK: true},
+		2: {at: 0, wantRetry: 500 * time.Millisecond},
+		3: {at: 250 * time.Millisecond, wantRetry: 250 * time.Millisecond},
+		4: {at: 500 * time.Millisecond, wantOK: true},
+		5: {at: 10 * time.Second, wantOK: true}, // Refills only up to Burst.
+		6: {at: 10 * time.Second, wantOK: true},
+		7: {at: 10 * time.Second, wantRetry: 500 * time.Millisecond},
+	}
+
+	for i, tt := range tests {
+		ok, retry := b.Take(limit, start.Add(tt.at))
+		if ok != tt.wantOK || retry != tt.wantRetry {
+			t.Errorf("#%d: got (%v, %v) want (%v, %v)", i, ok, retry, tt.wantOK, tt.wantRetry)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	backend, _ := memory.NewWithMap(map[string]string{apiKey1: string(bAPISecret1), apiKey2: string(bAPISecret2)})
+	if err := backend.SetLimit(apiKey2, &authmid.Limit{Rate: 1, Burst: 3}); err != nil {
+		t.Fatalf("SetLimit: %v", err)
+	}
+	ha := newHeaderAuthenticator()
+	ha.Backend = backend
+
+	now := time.Unix(1500000000, 0)
+	limiter := memory.NewRateLimiter()
+	limiter.Now = func() time.Time { return now }
+	limited, err := authmid.RateLimit(&authmid.RateLimitConfig{
+		Limiter:   limiter,
+		Default:   authmid.Limit{Rate: 0.5, Burst: 1},
+		Overrides: backend,
+	}, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	if err != nil {
+		t.Fatalf("RateLimit: %v", err)
+	}
+	handler := authmid.Middleware(ha, limited)
+
+	tests := [...]struct {
+		apiKey         string
+		secret         []byte
+		wantCode       int
+		wantRetryAfter string
+	}{
+		0: {apiKey: apiKey1, secret: bAPISecret1, wantCode: http.StatusOK},
+		1: {apiKey: apiKey1, secret: bAPISecret1, wantCode: http.StatusTooManyRequests, wantRetryAfter: "2"},
+		2: {apiKey: apiKey2, secret: bAPISecret2, wantCode: http.StatusOK},
+		3: {apiKey: apiKey2, secret: bAPISecret2, wantCode: http.StatusOK},
+		4: {apiKey: apiKey2, secret: bAPISecret2, wantCode: http.StatusOK},
+		5: {apiKey: apiKey2, secret: bAPISecret2, wantCode: http.StatusTooManyRequests, wantRetryAfter: "1"},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest("GET", "https://orijtech.com/", nil)
+		req.Header.Set("TEST-ACCESS-TIMESTAMP", "1500000000")
+		if err := ha.SignRequest(req, tt.apiKey, tt.secret); err != nil {
+			t.Fatalf("#%d: SignRequest: %v", i, err)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Errorf("#%d: got %d want %d; body: %s", i, rec.Code, tt.wantCode, rec.Body.String())
+		}
+		if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+			t.Errorf("#%d: got Retry-After %q want %q", i, got, tt.wantRetryAfter)
+		}
+	}
+}
+
+func TestInvalidLimits(t *testing.T) {
+	limiter := memory.NewRateLimiter()
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	for i, limit := range []authmid.Limit{{Rate: 0, Burst: 1}, {Rate: -1, Burst: 1}, {Rate: 1, Burst: 0}} {
+		if _, err := authmid.RateLimit(&authmid.RateLimitConfig{Limiter: limiter, Default: limit}, next); err != authmid.ErrInvalidLimit {
+			t.Errorf("#%d: RateLimit: got %v want %v", i, err, authmid.ErrInvalidLimit)
+		}
+		backend, _ := memory.NewWithMap(map[string]string{apiKey1: string(bAPISecret1)})
+		if err := backend.SetLimit(apiKey1, &limit); err != authmid.ErrInvalidLimit {
+			t.Errorf("#%d: SetLimit: got %v want %v", i, err, authmid.ErrInvalidLimit)
+		}
+	}
+}
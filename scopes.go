@@ -0,0 +1,126 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Principal is the identity that Middleware authenticated.
+type Principal struct {
+	APIKey string
+
+	// Scopes are populated if the Authenticator implements ScopeBackend.
+	Scopes []string
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	if p == nil {
+		return false
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// ScopeBackend is optionally implemented by backends, and Authenticators,
+// that store the scopes granted to each API key alongside its secret.
+type ScopeBackend interface {
+	LookupScopes(apiKey string) ([]string, error)
+}
+
+type ScopeWriter interface {
+	SetScopes(apiKey string, scopes []string) error
+}
+
+// Require returns middleware that only passes on requests whose Principal
+// holds every one of scopes, others are rejected with 403 Forbidden, or 401
+// Unauthorized if they weren't authenticated by Middleware at all.
+func Require(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return RequirePolicy(next, []Rule{{Scopes: scopes}})
+	}
+}
+
+// Rule requires Scopes of requests whose method is Method, or any if
+// blank, and whose path is within PathPrefix: "/admin" matches "/admin"
+// and "/admin/keys" but not "/administrator".
+type Rule struct {
+	Method     string
+	PathPrefix string
+	Scopes     []string
+}
+
+func (r *Rule) matches(req *http.Request) bool {
+	if r.Method != "" && !strings.EqualFold(r.Method, req.Method) {
+		return false
+	}
+	return withinPrefix(req.URL.Path, r.PathPrefix)
+}
+
+// withinPrefix reports whether urlPath is prefix or below it,
+// matching only whole path segments.
+func withinPrefix(urlPath, prefix string) bool {
+	if !strings.HasPrefix(urlPath, prefix) {
+		return false
+	}
+	rest := urlPath[len(prefix):]
+	return rest == "" || prefix == "" || strings.HasSuffix(prefix, "/") || rest[0] == '/'
+}
+
+// RequirePolicy applies the first of rules that matches each request,
+// requests that match none of them are forbidden.
+func RequirePolicy(next http.Handler, rules []Rule) http.Handler {
+	rules = append([]Rule(nil), rules...)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			http.Error(w, "unauthenticated request", http.StatusUnauthorized)
+			return
+		}
+		for i := range rules {
+			rule := &rules[i]
+			if !rule.matches(r) {
+				continue
+			}
+			for _, scope := range rule.Scopes {
+				if !principal.HasScope(scope) {
+					http.Error(w, "missing the "+strconv.Quote(scope)+" scope", http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, "no policy allows this request", http.StatusForbidden)
+	})
+}
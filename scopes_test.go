@@ -0,0 +1,74 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+)
+
+func TestRequirePolicy(t *testing.T) {
+	backend, _ := memory.NewWithMap(map[string]string{apiKey1: string(bAPISecret1)})
+	if err := backend.SetScopes(apiKey1, []string{"orders:read"}); err != nil {
+		t.Fatalf("SetScopes: %v", err)
+	}
+	ha := newHeaderAuthenticator()
+	ha.Backend = backend
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, _ := authmid.PrincipalFromContext(r.Context()); p.APIKey != apiKey1 {
+			t.Errorf("got principal %+v", p)
+		}
+	})
+	policy := authmid.RequirePolicy(ok, []authmid.Rule{
+		{Method: "GET", PathPrefix: "/orders", Scopes: []string{"orders:read"}},
+		{Method: "POST", PathPrefix: "/orders", Scopes: []string{"orders:write"}},
+		{PathPrefix: "/public/"},
+	})
+
+	tests := [...]struct {
+		method, path string
+		handler      http.Handler
+		wantCode     int
+	}{
+		0: {method: "GET", path: "/orders/1", handler: authmid.Middleware(ha, policy), wantCode: http.StatusOK},
+		1: {method: "POST", path: "/orders", handler: authmid.Middleware(ha, policy), wantCode: http.StatusForbidden},
+		2: {method: "GET", path: "/invoices", handler: authmid.Middleware(ha, policy), wantCode: http.StatusForbidden},
+		3: {method: "GET", path: "/orders/1", handler: policy, wantCode: http.StatusUnauthorized},
+		4: {method: "GET", path: "/", handler: authmid.Middleware(ha, authmid.Require("orders:read")(ok)), wantCode: http.StatusOK},
+		5: {method: "GET", path: "/", handler: authmid.Middleware(ha, authmid.Require("admin")(ok)), wantCode: http.StatusForbidden},
+		// Prefixes match whole path segments.
+		6: {method: "GET", path: "/orders", handler: authmid.Middleware(ha, policy), wantCode: http.StatusOK},
+		7: {method: "GET", path: "/ordersummary", handler: authmid.Middleware(ha, policy), wantCode: http.StatusForbidden},
+		8: {method: "POST", path: "/public/docs", handler: authmid.Middleware(ha, policy), wantCode: http.StatusOK},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest(tt.method, "https://orijtech.com"+tt.path, nil)
+		req.Header.Set("TEST-ACCESS-TIMESTAMP", "1500000000")
+		if err := ha.SignRequest(req, apiKey1, bAPISecret1); err != nil {
+			t.Fatalf("#%d: SignRequest: %v", i, err)
+		}
+		rec := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Errorf("#%d: got %d want %d; body: %s", i, rec.Code, tt.wantCode, rec.Body.String())
+		}
+	}
+}
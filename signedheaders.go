@@ -0,0 +1,130 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Pseudo header names that SignedHeaders resolves from the request itself.
+const (
+	SignedHost          = "host"
+	SignedContentLength = "content-length"
+	SignedScheme        = ":scheme"
+)
+
+// SignedHeaders declares the headers that are bound into a signature.
+// Each header is rendered as its lowercased name, a colon, and its values
+// trimmed, joined by "," and with inner runs of whitespace collapsed,
+// one per line. Absent headers are signed with an empty value, so they
+// can't be added after signing either.
+//
+// "host" and "content-length" are taken from the request rather than its
+// header map, because net/http moves them out of it on servers, and
+// ":scheme" signs "http" or "https".
+type SignedHeaders struct {
+	// Names are always signed, in order.
+	Names []string
+
+	// ListHeader if set names a header in which clients list the other
+	// header names that they signed, separated by ";" as in SigV4's
+	// SignedHeaders e.g. "Authmid-Signed-Headers: content-type;x-date".
+	ListHeader string
+
+	// ForwardedProtoHeader if set e.g. "X-Forwarded-Proto" is trusted
+	// for the scheme of requests that arrive via a TLS terminating proxy.
+	ForwardedProtoHeader string
+}
+
+// Canonical returns the signed rendering of req's headers.
+func (sh *SignedHeaders) Canonical(req *http.Request, body []byte) string {
+	if sh == nil {
+		return ""
+	}
+	var lines []string
+	for _, name := range sh.names(req) {
+		lines = append(lines, name+":"+sh.value(req, body, name))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (sh *SignedHeaders) names(req *http.Request) []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, name := range sh.Names {
+		add(name)
+	}
+	if sh.ListHeader != "" {
+		// The list header is itself signed so that it can't be altered.
+		add(sh.ListHeader)
+		for _, name := range strings.Split(req.Header.Get(sh.ListHeader), ";") {
+			add(name)
+		}
+	}
+	return names
+}
+
+func (sh *SignedHeaders) value(req *http.Request, body []byte, name string) string {
+	switch name {
+	case SignedHost:
+		host := req.Host
+		if host == "" && req.URL != nil {
+			host = req.URL.Host
+		}
+		return strings.ToLower(normalizeHeaderValue(host))
+	case SignedContentLength:
+		// Checker reads the entire body so its length is known on both sides.
+		return strconv.Itoa(len(body))
+	case SignedScheme:
+		return sh.scheme(req)
+	}
+	values := req.Header[http.CanonicalHeaderKey(name)]
+	normalized := make([]string, 0, len(values))
+	for _, value := range values {
+		normalized = append(normalized, normalizeHeaderValue(value))
+	}
+	return strings.Join(normalized, ",")
+}
+
+func (sh *SignedHeaders) scheme(req *http.Request) string {
+	if req.URL != nil && req.URL.Scheme != "" {
+		return strings.ToLower(req.URL.Scheme)
+	}
+	if sh.ForwardedProtoHeader != "" {
+		if proto := req.Header.Get(sh.ForwardedProtoHeader); proto != "" {
+			return strings.ToLower(normalizeHeaderValue(proto))
+		}
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func normalizeHeaderValue(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
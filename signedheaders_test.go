@@ -0,0 +1,113 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/orijtech/authmid"
+)
+
+func TestSignedHeadersCanonical(t *testing.T) {
+	req, _ := http.NewRequest("POST", "https://API.orijtech.com/v1", nil)
+	req.Header.Set("Content-Type", "  application/json ")
+	req.Header.Add("X-Tags", "a   b")
+	req.Header.Add("X-Tags", "c")
+	req.Header.Set("Authmid-Signed-Headers", "X-Tags; x-absent")
+
+	sh := &authmid.SignedHeaders{
+		Names:      []string{"Host", ":scheme", "Content-Type", "content-length"},
+		ListHeader: "Authmid-Signed-Headers",
+	}
+	got := sh.Canonical(req, []byte("12345"))
+	want := "host:api.orijtech.com\n" +
+		":scheme:https\n" +
+		"content-type:application/json\n" +
+		"content-length:5\n" +
+		"authmid-signed-headers:X-Tags; x-absent\n" +
+		"x-tags:a b,c\n" +
+		"x-absent:\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+type signedHeadersAuthChecker struct {
+	sampleAuthChecker
+	sh *authmid.SignedHeaders
+}
+
+func (sa *signedHeadersAuthChecker) Canonicalization() authmid.Canonicalization {
+	return authmid.Canonicalization{Headers: sa.sh}
+}
+
+func TestSignedHeadersBindHost(t *testing.T) {
+	sh := &authmid.SignedHeaders{
+		Names:      []string{authmid.SignedHost, authmid.SignedScheme, "Content-Type", authmid.SignedContentLength},
+		ListHeader: "Authmid-Signed-Headers",
+	}
+	auth := &signedHeadersAuthChecker{sh: sh}
+	srv := httptest.NewServer(authmid.Middleware(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})))
+	defer srv.Close()
+
+	body := []byte(`{"amount": 100}`)
+	newSignedReq := func() *http.Request {
+		req, _ := http.NewRequest("POST", srv.URL+"/charges", bytes.NewReader(body))
+		req.Host = "api.staging.orijtech.com"
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Idempotency-Key", "abc")
+		req.Header.Set("Authmid-Signed-Headers", "x-idempotency-key")
+		req.Header.Set("TEST-ACCESS-TIMESTAMP", "1500000000")
+		req.Header.Set("TEST-ACCESS-KEY", apiKey1)
+		sig, err := authmid.Sign(bAPISecret1, req, []string{"1500000000"}, authmid.Canonicalization{Headers: sh})
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		req.Header.Set("TEST-ACCESS-SIGN", sig)
+		return req
+	}
+
+	tests := [...]struct {
+		tamper   func(*http.Request)
+		wantCode int
+	}{
+		0: {tamper: func(*http.Request) {}, wantCode: http.StatusOK},
+		1: {tamper: func(r *http.Request) { r.Host = "api.orijtech.com" }, wantCode: http.StatusBadRequest},
+		2: {tamper: func(r *http.Request) { r.Header.Set("Content-Type", "text/plain") }, wantCode: http.StatusBadRequest},
+		3: {tamper: func(r *http.Request) { r.Header.Set("X-Idempotency-Key", "xyz") }, wantCode: http.StatusBadRequest},
+		4: {tamper: func(r *http.Request) { r.Header.Set("Authmid-Signed-Headers", "") }, wantCode: http.StatusBadRequest},
+		5: {tamper: func(r *http.Request) { r.Header.Set("X-Unsigned", "free") }, wantCode: http.StatusOK},
+	}
+
+	for i, tt := range tests {
+		req := newSignedReq()
+		tt.tamper(req)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		blob, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.wantCode {
+			t.Errorf("#%d: got %d want %d; body: %s", i, res.StatusCode, tt.wantCode, blob)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// FailurePolicy locks a source out for Lockout once it has
+// failed MaxFailures times within Window.
+type FailurePolicy struct {
+	MaxFailures int
+	Window      time.Duration
+	Lockout     time.Duration
+}
+
+// FailureTracker counts authentication failures by key, which
+// ThrottledMiddleware prefixes with "key:" or "ip:".
+type FailureTracker interface {
+	// Fail records a failure of key and reports whether it locked key out.
+	Fail(key string, policy FailurePolicy) (locked bool, err error)
+
+	// LockedOut returns how much longer key is locked out for, 0 if it isn't.
+	LockedOut(key string) (time.Duration, error)
+}
+
+type LockoutEvent struct {
+	// APIKey is set for lockouts of an API key, IP for those of a client IP.
+	APIKey string
+	IP     string
+
+	Until time.Time
+}
+
+type ThrottleConfig struct {
+	Tracker FailureTracker
+	Policy  FailurePolicy
+
+	// ClientIP if set extracts the client IP, e.g. from X-Forwarded-For
+	// behind a trusted proxy. By default it is the host of RemoteAddr.
+	ClientIP func(*http.Request) string
+
+	// OnLockout if set is called whenever a key or IP gets locked out.
+	OnLockout func(*LockoutEvent)
+}
+
+var (
+	errNilThrottleConfig = errors.New("expecting a non-nil throttle config")
+	errNilTracker        = errors.New("expecting a non-nil failure tracker")
+)
+
+// ThrottledMiddleware is like Middleware but it locks out API keys and client
+// IPs whose requests fail with ErrSignatureMismatch or ErrNoSuchAPIKey too
+// often, their requests are rejected with 429 Too Many Requests and a
+// Retry-After header until the lockout expires.
+func ThrottledMiddleware(vf Authenticator, cfg *ThrottleConfig, next http.Handler) (http.Handler, error) {
+	if cfg == nil {
+		return nil, errNilThrottleConfig
+	}
+	if cfg.Tracker == nil {
+		return nil, errNilTracker
+	}
+	tcfg := *cfg
+	if tcfg.ClientIP == nil {
+		tcfg.ClientIP = RemoteIP
+	}
+	authenticate := authenticator(vf)
+	throttled := func(req *http.Request) (*Principal, error) {
+		ip := tcfg.ClientIP(req)
+		var apiKey string
+		if req != nil && req.Header != nil {
+			apiKey, _ = vf.LookupAPIKey(req.Header)
+			recordAPIKey(req, apiKey)
+		}
+		return tcfg.Throttle(apiKey, ip, func() (*Principal, error) {
+			return authenticate(req)
+		})
+	}
+	return &auther{authenticate: throttled, next: next}, nil
+}
+
+// Throttle is ThrottledMiddleware for requests that don't come over
+// net/http: it fails with a *LockedOutError while apiKey or ip is locked
+// out, and otherwise counts the failures of authenticate against both.
+// Either may be blank. cfg.ClientIP is unused.
+func (cfg *ThrottleConfig) Throttle(apiKey, ip string, authenticate func() (*Principal, error)) (*Principal, error) {
+	if err := cfg.checkLockouts(apiKey, ip); err != nil {
+		return nil, err
+	}
+	principal, err := authenticate()
+	if err != nil && (errors.Is(err, ErrSignatureMismatch) || errors.Is(err, ErrNoSuchAPIKey)) {
+		cfg.fail(apiKey, ip)
+	}
+	return principal, err
+}
+
+func (cfg *ThrottleConfig) checkLockouts(apiKey, ip string) error {
+	var keys []string
+	if apiKey != "" {
+		keys = append(keys, "key:"+apiKey)
+	}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	for _, key := range keys {
+		remaining, err := cfg.Tracker.LockedOut(key)
+		if err != nil {
+			// The tracker failed, not the request.
+			return &InternalError{Err: err}
+		}
+		if remaining > 0 {
+			return &LockedOutError{RetryAfter: remaining}
+		}
+	}
+	return nil
+}
+
+func (cfg *ThrottleConfig) fail(apiKey, ip string) {
+	// Tracking is best effort, the request has failed regardless.
+	if apiKey != "" {
+		if locked, _ := cfg.Tracker.Fail("key:"+apiKey, cfg.Policy); locked {
+			cfg.emit(&LockoutEvent{APIKey: apiKey})
+		}
+	}
+	if ip != "" {
+		if locked, _ := cfg.Tracker.Fail("ip:"+ip, cfg.Policy); locked {
+			cfg.emit(&LockoutEvent{IP: ip})
+		}
+	}
+}
+
+func (cfg *ThrottleConfig) emit(ev *LockoutEvent) {
+	if cfg.OnLockout == nil {
+		return
+	}
+	ev.Until = time.Now().Add(cfg.Policy.Lockout)
+	cfg.OnLockout(ev)
+}
+
+// RemoteIP returns the host of req.RemoteAddr.
+func RemoteIP(req *http.Request) string {
+	if req == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+type LockedOutError struct {
+	RetryAfter time.Duration
+}
+
+var _ CodedError = (*LockedOutError)(nil)
+
+func (le *LockedOutError) Error() string {
+	return "too many failed attempts, locked out for " + le.RetryAfter.Round(time.Second).String()
+}
+
+func (le *LockedOutError) Code() int { return http.StatusTooManyRequests }
+
+// FailureCount is the FailureTracker arithmetic for a fixed window,
+// shared by the implementations so that they agree on it.
+type FailureCount struct {
+	Failures    int
+	WindowStart time.Time
+	LockedUntil time.Time
+}
+
+// Fail records a failure at now and reports whether it locked fc out.
+func (fc *FailureCount) Fail(policy FailurePolicy, now time.Time) bool {
+	if now.Sub(fc.WindowStart) >= policy.Window {
+		fc.Failures = 0
+		fc.WindowStart = now
+	}
+	fc.Failures++
+	if policy.MaxFailures <= 0 || fc.Failures < policy.MaxFailures {
+		return false
+	}
+	fc.Failures = 0
+	fc.LockedUntil = now.Add(policy.Lockout)
+	return true
+}
+
+// Expired reports whether fc's window and lockout have both passed by now,
+// when it's indistinguishable from a new count and can be forgotten.
+func (fc *FailureCount) Expired(policy FailurePolicy, now time.Time) bool {
+	return now.Sub(fc.WindowStart) >= policy.Window && fc.LockedOut(now) == 0
+}
+
+func (fc *FailureCount) LockedOut(now time.Time) time.Duration {
+	if remaining := fc.LockedUntil.Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+)
+
+func TestThrottledMiddleware(t *testing.T) {
+	ha := newHeaderAuthenticator()
+	now := time.Unix(1500000000, 0)
+	tracker := memory.NewFailureTracker()
+	tracker.Now = func() time.Time { return now }
+
+	var events []*authmid.LockoutEvent
+	handler, err := authmid.ThrottledMiddleware(ha, &authmid.ThrottleConfig{
+		Tracker:   tracker,
+		Policy:    authmid.FailurePolicy{MaxFailures: 2, Window: time.Minute, Lockout: 5 * time.Minute},
+		OnLockout: func(ev *authmid.LockoutEvent) { events = append(events, ev) },
+	}, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	if err != nil {
+		t.Fatalf("ThrottledMiddleware: %v", err)
+	}
+
+	tests := [...]struct {
+		ip             string
+		apiKey         string
+		secret         []byte
+		advance        time.Duration
+		wantCode       int
+		wantRetryAfter string
+		wantEvents     int
+	}{
+		0: {ip: "192.0.2.1", apiKey: apiKey1, secret: bAPISecret2, wantCode: http.StatusBadRequest},
+		1: {ip: "192.0.2.2", apiKey: apiKey1, secret: bAPISecret2, wantCode: http.StatusBadRequest, wantEvents: 1},
+
+		// The key is locked out even with its secret and from a fresh IP.
+		2: {ip: "192.0.2.3", apiKey: apiKey1, secret: bAPISecret1, wantCode: http.StatusTooManyRequests, wantRetryAfter: "300", wantEvents: 1},
+		3: {ip: "192.0.2.3", apiKey: apiKey1, secret: bAPISecret1, advance: 5 * time.Minute, wantCode: http.StatusOK, wantEvents: 1},
+
+		// Key enumeration from one IP locks the IP out.
+		4: {ip: "192.0.2.9", apiKey: "unknown-1", secret: bAPISecret1, wantCode: http.StatusBadRequest, wantEvents: 1},
+		5: {ip: "192.0.2.9", apiKey: "unknown-2", secret: bAPISecret1, wantCode: http.StatusBadRequest, wantEvents: 2},
+		6: {ip: "192.0.2.9", apiKey: apiKey1, secret: bAPISecret1, advance: time.Minute, wantCode: http.StatusTooManyRequests, wantRetryAfter: "240", wantEvents: 2},
+	}
+
+	for i, tt := range tests {
+		now = now.Add(tt.advance)
+		req := httptest.NewRequest("GET", "https://orijtech.com/", nil)
+		req.RemoteAddr = tt.ip + ":4242"
+		req.Header.Set("TEST-ACCESS-TIMESTAMP", "1500000000")
+		if err := ha.SignRequest(req, tt.apiKey, tt.secret); err != nil {
+			t.Fatalf("#%d: SignRequest: %v", i, err)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Errorf("#%d: got %d want %d; body: %s", i, rec.Code, tt.wantCode, rec.Body.String())
+		}
+		if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+			t.Errorf("#%d: got Retry-After %q want %q", i, got, tt.wantRetryAfter)
+		}
+		if len(events) != tt.wantEvents {
+			t.Errorf("#%d: got %d lockout events want %d", i, len(events), tt.wantEvents)
+		}
+	}
+	if len(events) != 2 || events[0].APIKey != apiKey1 || events[1].IP != "192.0.2.9" {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+type brokenTracker struct{}
+
+var errTrackerDown = errors.New("dial tcp 10.0.0.7:6379: connection refused")
+
+func (brokenTracker) Fail(string, authmid.FailurePolicy) (bool, error) { return false, errTrackerDown }
+func (brokenTracker) LockedOut(string) (time.Duration, error)         { return 0, errTrackerDown }
+
+func TestThrottledMiddlewareTrackerFailure(t *testing.T) {
+	ha := newHeaderAuthenticator()
+	var audited eventLog
+	throttled, err := authmid.ThrottledMiddleware(ha, &authmid.ThrottleConfig{
+		Tracker: brokenTracker{},
+		Policy:  authmid.FailurePolicy{MaxFailures: 2, Window: time.Minute, Lockout: time.Minute},
+	}, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	if err != nil {
+		t.Fatalf("ThrottledMiddleware: %v", err)
+	}
+	handler, _ := authmid.Audit(&authmid.AuditConfig{Sink: &audited}, throttled)
+
+	req := httptest.NewRequest("GET", "https://orijtech.com/", nil)
+	req.Header.Set("TEST-ACCESS-TIMESTAMP", "1500000000")
+	if err := ha.SignRequest(req, apiKey1, bAPISecret1); err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if body := rec.Body.String(); rec.Code != http.StatusInternalServerError || strings.Contains(body, "10.0.0.7") {
+		t.Errorf("got %d %q want a bare 500", rec.Code, body)
+	}
+	// The details are kept for the logs.
+	if len(audited) != 1 || !errors.Is(audited[0].Err, errTrackerDown) {
+		t.Errorf("got audit events %+v", audited)
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultTolerance is how old, or how far in the future, a signed
+// timestamp may be when no tolerance is configured.
+const DefaultTolerance = 5 * time.Minute
+
+var ErrTimestampOutsideTolerance = errors.New("timestamp is outside of the tolerance")
+
+// WithinTolerance checks that ts, in Unix seconds, is within tolerance of
+// now, which defaults to time.Now. A zero tolerance is DefaultTolerance and
+// negative ones disable the check, e.g. to replay stored events.
+func WithinTolerance(ts int64, tolerance time.Duration, now func() time.Time) error {
+	if tolerance == 0 {
+		tolerance = DefaultTolerance
+	}
+	if tolerance < 0 {
+		return nil
+	}
+	if now == nil {
+		now = time.Now
+	}
+	delta := now().Sub(time.Unix(ts, 0))
+	if delta < -tolerance || delta > tolerance {
+		return ErrTimestampOutsideTolerance
+	}
+	return nil
+}
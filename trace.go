@@ -0,0 +1,51 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation name of authmid's spans. They're
+// recorded with the global TracerProvider, a no-op unless one is set
+// with otel.SetTracerProvider.
+const TracerName = "github.com/orijtech/authmid"
+
+// Span attributes of authentication.
+const (
+	AttrAPIKey        = "authmid.api_key"
+	AttrOutcome       = "authmid.outcome"
+	AttrFailureReason = "authmid.failure_reason"
+)
+
+func tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+func recordSpan(span trace.Span, err error) {
+	if err == nil {
+		span.SetAttributes(attribute.String(AttrOutcome, string(Allowed)))
+		return
+	}
+	reason := failureReason(err)
+	span.SetAttributes(
+		attribute.String(AttrOutcome, string(Denied)),
+		attribute.String(AttrFailureReason, reason),
+	)
+	span.SetStatus(codes.Error, reason)
+}
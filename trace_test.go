@@ -0,0 +1,91 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authmid_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/orijtech/authmid"
+)
+
+// tracedBackend records its lookups as spans, as backend/redis does.
+type tracedBackend struct {
+	authmid.ReadOnlyBackend
+}
+
+func (tb *tracedBackend) LookupSecretContext(ctx context.Context, apiKey string) ([]byte, error) {
+	_, span := otel.Tracer("test").Start(ctx, "lookup")
+	defer span.End()
+	return tb.LookupSecret(apiKey)
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(prev)
+
+	ha := newHeaderAuthenticator()
+	ha.Backend = &tracedBackend{ReadOnlyBackend: ha.Backend}
+	handler := authmid.Middleware(ha, http.NotFoundHandler())
+
+	for _, secret := range [][]byte{bAPISecret1, bAPISecret2} {
+		req := httptest.NewRequest("GET", "https://orijtech.com/", nil)
+		req.Header.Set("TEST-ACCESS-TIMESTAMP", "1500000000")
+		if err := ha.SignRequest(req, apiKey1, secret); err != nil {
+			t.Fatalf("SignRequest: %v", err)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans want 4", len(spans))
+	}
+	for i, want := range []struct {
+		outcome, reason string
+		code            codes.Code
+	}{
+		{outcome: "allowed", code: codes.Unset},
+		{outcome: "denied", reason: authmid.ErrSignatureMismatch.Error(), code: codes.Error},
+	} {
+		lookup, auth := spans[2*i], spans[2*i+1]
+		if lookup.Name() != "lookup" || auth.Name() != "authmid.authenticate" {
+			t.Fatalf("#%d: got spans %q, %q", i, lookup.Name(), auth.Name())
+		}
+		if lookup.Parent().SpanID() != auth.SpanContext().SpanID() {
+			t.Errorf("#%d: the lookup isn't a child of the authentication", i)
+		}
+		attrs := make(map[attribute.Key]string)
+		for _, kv := range auth.Attributes() {
+			attrs[kv.Key] = kv.Value.AsString()
+		}
+		if attrs[authmid.AttrAPIKey] != apiKey1 || attrs[authmid.AttrOutcome] != want.outcome || attrs[authmid.AttrFailureReason] != want.reason {
+			t.Errorf("#%d: got attributes %v", i, attrs)
+		}
+		if auth.Status().Code != want.code {
+			t.Errorf("#%d: got status %v want %v", i, auth.Status().Code, want.code)
+		}
+	}
+}
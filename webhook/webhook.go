@@ -0,0 +1,294 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook delivers signed webhooks, using the scheme that a
+// receiver verifies with authmid.Checker, retrying failed deliveries with
+// exponential backoff and jitter within a retry budget.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/orijtech/authmid"
+)
+
+type Subscriber struct {
+	// APIKey identifies the subscriber and looks up its secret.
+	APIKey string
+	URL    string
+}
+
+type Attempt struct {
+	Number     int
+	Start      time.Time
+	Duration   time.Duration
+	StatusCode int
+	Err        error
+}
+
+type Delivery struct {
+	Subscriber *Subscriber
+	Attempts   []*Attempt
+	Delivered  bool
+}
+
+// Recorder is optionally invoked with every delivery attempt, e.g. to persist them.
+type Recorder interface {
+	RecordAttempt(sub *Subscriber, attempt *Attempt)
+}
+
+type Sender struct {
+	// Scheme describes the headers to sign and send, and looks up each
+	// subscriber's secret from its Backend. Receivers verify the
+	// deliveries with an equivalent HeaderAuthenticator.
+	Scheme *authmid.HeaderAuthenticator
+
+	// TimestampHeader if set is sent with the Unix time of each attempt,
+	// list it in Scheme.Headers for it to be signed.
+	TimestampHeader string
+
+	Client *http.Client
+
+	// MaxAttempts defaults to 5.
+	MaxAttempts int
+
+	// InitialBackoff defaults to 1s and is doubled after every failed
+	// attempt up to MaxBackoff, which defaults to 1m. Each wait is
+	// drawn uniformly from [0, backoff) to spread out retries.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Budget if set limits the retries across all deliveries.
+	Budget *RetryBudget
+
+	Recorder Recorder
+}
+
+const (
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+)
+
+var (
+	ErrRetryBudgetExhausted = errors.New("webhook retry budget exhausted")
+
+	errNilScheme     = errors.New("expecting a non-nil scheme")
+	errNilSubscriber = errors.New("expecting a non-nil subscriber")
+)
+
+type deliveryError struct {
+	statusCode int
+}
+
+func (de *deliveryError) Error() string {
+	return fmt.Sprintf("webhook delivery failed: %d %s", de.statusCode, http.StatusText(de.statusCode))
+}
+
+// requestError is a failure to build or sign a request, which
+// would fail the same way on every attempt.
+type requestError struct {
+	err error
+}
+
+func (re *requestError) Error() string {
+	return "webhook request: " + re.err.Error()
+}
+
+func (re *requestError) Unwrap() error {
+	return re.err
+}
+
+// Send delivers payload to sub, retrying until it is accepted with a 2XX
+// status, a non-retryable status is returned, the attempts or budget are
+// exhausted, or ctx is done. The returned Delivery is never nil.
+func (s *Sender) Send(ctx context.Context, sub *Subscriber, contentType string, payload []byte) (*Delivery, error) {
+	delivery := &Delivery{Subscriber: sub}
+	if s.Scheme == nil {
+		return delivery, errNilScheme
+	}
+	if sub == nil {
+		return delivery, errNilSubscriber
+	}
+	secret, err := s.Scheme.LookupSecret(sub.APIKey)
+	if err != nil {
+		return delivery, err
+	}
+
+	maxAttempts := s.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	backoff := s.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultInitialBackoff
+	}
+	maxBackoff := s.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	if s.Budget != nil {
+		s.Budget.deposit()
+	}
+
+	for n := 1; ; n++ {
+		attempt, retryAfter := s.attempt(ctx, sub, contentType, payload, secret, n)
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		if s.Recorder != nil {
+			s.Recorder.RecordAttempt(sub, attempt)
+		}
+		if attempt.Err == nil {
+			delivery.Delivered = true
+			return delivery, nil
+		}
+		if !retryable(attempt) {
+			return delivery, attempt.Err
+		}
+		if n >= maxAttempts {
+			return delivery, fmt.Errorf("webhook undelivered after %d attempts: %w", n, attempt.Err)
+		}
+		if s.Budget != nil && !s.Budget.withdraw() {
+			return delivery, fmt.Errorf("%w, last attempt: %w", ErrRetryBudgetExhausted, attempt.Err)
+		}
+
+		wait := time.Duration(rand.Int63n(int64(backoff)))
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return delivery, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Sender) attempt(ctx context.Context, sub *Subscriber, contentType string, payload, secret []byte, n int) (attempt *Attempt, retryAfter time.Duration) {
+	attempt = &Attempt{Number: n, Start: time.Now()}
+	defer func() {
+		attempt.Duration = time.Since(attempt.Start)
+	}()
+
+	req, err := http.NewRequest("POST", sub.URL, bytes.NewReader(payload))
+	if err != nil {
+		attempt.Err = &requestError{err: err}
+		return attempt, 0
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if s.TimestampHeader != "" {
+		req.Header.Set(s.TimestampHeader, strconv.FormatInt(attempt.Start.Unix(), 10))
+	}
+	if err := s.Scheme.SignRequest(req, sub.APIKey, secret); err != nil {
+		attempt.Err = &requestError{err: err}
+		return attempt, 0
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		attempt.Err = err
+		return attempt, 0
+	}
+	// Drain some of the body so that the connection can be reused.
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+	res.Body.Close()
+
+	attempt.StatusCode = res.StatusCode
+	if res.StatusCode/100 != 2 {
+		attempt.Err = &deliveryError{statusCode: res.StatusCode}
+	}
+	if secs, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && secs > 0 {
+		retryAfter = time.Duration(secs) * time.Second
+	}
+	return attempt, retryAfter
+}
+
+// retryable reports whether a failed attempt is worth retrying: transport
+// errors, throttling and server errors are, other client errors and
+// requests that couldn't be built or signed aren't.
+func retryable(attempt *Attempt) bool {
+	var re *requestError
+	if errors.As(attempt.Err, &re) {
+		return false
+	}
+	switch code := attempt.StatusCode; {
+	case code == 0:
+		return true
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		return true
+	default:
+		return code >= 500
+	}
+}
+
+// RetryBudget bounds retries to a fraction of deliveries so that a failing
+// subscriber can't multiply the load on it. Every delivery deposits Ratio
+// tokens, up to Max, and every retry withdraws one.
+type RetryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	max    float64
+	tokens float64
+}
+
+// NewRetryBudget returns a budget that starts out full e.g.
+// NewRetryBudget(0.1, 100) allows retries amounting to 10% of deliveries
+// with bursts of up to 100 retries.
+func NewRetryBudget(ratio, max float64) *RetryBudget {
+	return &RetryBudget{ratio: ratio, max: max, tokens: max}
+}
+
+func (rb *RetryBudget) deposit() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.tokens += rb.ratio; rb.tokens > rb.max {
+		rb.tokens = rb.max
+	}
+}
+
+func (rb *RetryBudget) withdraw() bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.tokens < 1 {
+		return false
+	}
+	rb.tokens--
+	return true
+}
@@ -0,0 +1,198 @@
+// Copyright 2017 orijtech. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/orijtech/authmid"
+	"github.com/orijtech/authmid/backend/memory"
+	"github.com/orijtech/authmid/webhook"
+)
+
+func newScheme() *authmid.HeaderAuthenticator {
+	backend, _ := memory.NewWithMap(map[string]string{"sub1": "sub1-secret"})
+	return &authmid.HeaderAuthenticator{
+		Backend:         backend,
+		KeyHeader:       "Webhook-Key",
+		SignatureHeader: "Webhook-Signature",
+		Headers:         []authmid.HeaderSpec{{Name: "Webhook-Timestamp"}},
+	}
+}
+
+type flakyReceiver struct {
+	mu       sync.Mutex
+	failures int
+	code     int
+	bodies   []string
+}
+
+func (fr *flakyReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	if fr.failures > 0 {
+		fr.failures--
+		w.WriteHeader(fr.code)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	fr.bodies = append(fr.bodies, string(body))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type attemptLog struct {
+	mu       sync.Mutex
+	attempts []*webhook.Attempt
+}
+
+func (al *attemptLog) RecordAttempt(sub *webhook.Subscriber, attempt *webhook.Attempt) {
+	al.mu.Lock()
+	al.attempts = append(al.attempts, attempt)
+	al.mu.Unlock()
+}
+
+func TestSend(t *testing.T) {
+	tests := [...]struct {
+		failures, code int
+		maxAttempts    int
+		budget         *webhook.RetryBudget
+		wantAttempts   int
+		wantDelivered  bool
+		wantErr        error
+	}{
+		0: {wantAttempts: 1, wantDelivered: true},
+		1: {failures: 2, code: http.StatusServiceUnavailable, wantAttempts: 3, wantDelivered: true},
+		2: {failures: 2, code: http.StatusTooManyRequests, wantAttempts: 3, wantDelivered: true},
+		3: {failures: 1, code: http.StatusBadRequest, wantAttempts: 1},
+		4: {failures: 10, code: http.StatusInternalServerError, maxAttempts: 4, wantAttempts: 4},
+		5: {
+			failures: 10, code: http.StatusInternalServerError,
+			budget:       webhook.NewRetryBudget(0.1, 1),
+			wantAttempts: 2, wantErr: webhook.ErrRetryBudgetExhausted,
+		},
+	}
+
+	for i, tt := range tests {
+		scheme := newScheme()
+		receiver := &flakyReceiver{failures: tt.failures, code: tt.code}
+		srv := httptest.NewServer(authmid.Middleware(scheme, receiver))
+
+		log := new(attemptLog)
+		s := &webhook.Sender{
+			Scheme:          scheme,
+			TimestampHeader: "Webhook-Timestamp",
+			MaxAttempts:     tt.maxAttempts,
+			InitialBackoff:  time.Millisecond,
+			MaxBackoff:      5 * time.Millisecond,
+			Budget:          tt.budget,
+			Recorder:        log,
+		}
+		sub := &webhook.Subscriber{APIKey: "sub1", URL: srv.URL + "/hooks"}
+		delivery, err := s.Send(context.Background(), sub, "application/json", []byte(`{"event": "paid"}`))
+		srv.Close()
+
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("#%d: got err=%v want %v", i, err, tt.wantErr)
+		}
+		if gotErr := err != nil; gotErr == tt.wantDelivered {
+			t.Errorf("#%d: unexpected err=%v", i, err)
+		}
+		if delivery.Delivered != tt.wantDelivered {
+			t.Errorf("#%d: Delivered=%v want %v", i, delivery.Delivered, tt.wantDelivered)
+		}
+		if len(delivery.Attempts) != tt.wantAttempts || len(log.attempts) != tt.wantAttempts {
+			t.Errorf("#%d: got %d attempts (%d recorded) want %d", i, len(delivery.Attempts), len(log.attempts), tt.wantAttempts)
+		}
+		if tt.wantDelivered && (len(receiver.bodies) != 1 || receiver.bodies[0] != `{"event": "paid"}`) {
+			t.Errorf("#%d: receiver got %q", i, receiver.bodies)
+		}
+	}
+}
+
+func TestSendUnknownSubscriber(t *testing.T) {
+	s := &webhook.Sender{Scheme: newScheme()}
+	sub := &webhook.Subscriber{APIKey: "unknown", URL: "http://localhost:0"}
+	if _, err := s.Send(context.Background(), sub, "", nil); err == nil {
+		t.Errorf("expected an error for a subscriber without a secret")
+	}
+}
+
+func TestSendContextCancellation(t *testing.T) {
+	scheme := newScheme()
+	srv := httptest.NewServer(authmid.Middleware(scheme, &flakyReceiver{failures: 100, code: http.StatusBadGateway}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s := &webhook.Sender{
+		Scheme:          scheme,
+		TimestampHeader: "Webhook-Timestamp",
+		MaxAttempts:     1000,
+		InitialBackoff:  10 * time.Millisecond,
+		MaxBackoff:      10 * time.Millisecond,
+	}
+	delivery, err := s.Send(ctx, &webhook.Subscriber{APIKey: "sub1", URL: srv.URL}, "", []byte("{}"))
+	if err != context.DeadlineExceeded {
+		t.Errorf("got err=%v want %v", err, context.DeadlineExceeded)
+	}
+	if delivery.Delivered || len(delivery.Attempts) == 0 {
+		t.Errorf("unexpected delivery: %+v", delivery)
+	}
+}
+
+func TestSendLastError(t *testing.T) {
+	scheme := newScheme()
+	srv := httptest.NewServer(authmid.Middleware(scheme, &flakyReceiver{failures: 10, code: http.StatusBadGateway}))
+	defer srv.Close()
+
+	s := &webhook.Sender{
+		Scheme:          scheme,
+		TimestampHeader: "Webhook-Timestamp",
+		MaxAttempts:     2,
+		InitialBackoff:  time.Millisecond,
+	}
+	_, err := s.Send(context.Background(), &webhook.Subscriber{APIKey: "sub1", URL: srv.URL}, "", []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("got err=%v, expecting the last attempt's 502", err)
+	}
+}
+
+func TestSendLocalFailures(t *testing.T) {
+	urls := []string{
+		// Malformed.
+		0: "http://[::1",
+		// Well formed, but the Sender doesn't set the signed timestamp header.
+		1: "http://localhost:0",
+	}
+	for i, url := range urls {
+		s := &webhook.Sender{Scheme: newScheme(), InitialBackoff: time.Hour}
+		delivery, err := s.Send(context.Background(), &webhook.Subscriber{APIKey: "sub1", URL: url}, "", []byte("{}"))
+		if err == nil {
+			t.Errorf("#%d: expected an error", i)
+		}
+		if len(delivery.Attempts) != 1 {
+			t.Errorf("#%d: got %d attempts, expecting no retries", i, len(delivery.Attempts))
+		}
+	}
+}